@@ -6,8 +6,11 @@ import (
 	"path/filepath"
 	"time"
 
+	"github.com/MiXiaoAi/oinote/backend/internal/collab"
 	"github.com/MiXiaoAi/oinote/backend/internal/middleware"
+	"github.com/MiXiaoAi/oinote/backend/internal/links"
 	"github.com/MiXiaoAi/oinote/backend/internal/models"
+	"github.com/MiXiaoAi/oinote/backend/internal/search"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/gofiber/fiber/v2"
 	"golang.org/x/crypto/bcrypt"
@@ -15,11 +18,12 @@ import (
 )
 
 type AuthHandler struct {
-	DB *gorm.DB
+	DB     *gorm.DB
+	Collab *collab.YjsServer
 }
 
-func NewAuthHandler(db *gorm.DB) *AuthHandler {
-	return &AuthHandler{DB: db}
+func NewAuthHandler(db *gorm.DB, yjsServer *collab.YjsServer) *AuthHandler {
+	return &AuthHandler{DB: db, Collab: yjsServer}
 }
 
 func (h *AuthHandler) Register(c *fiber.Ctx) error {
@@ -134,7 +138,7 @@ func (h *AuthHandler) GetStats(c *fiber.Ctx) error {
 	// 基础统计
 	h.DB.Model(&models.User{}).Count(&stats.UserCount)
 	h.DB.Model(&models.Note{}).Count(&stats.NoteCount)
-	h.DB.Model(&models.Channel{}).Count(&stats.ChannelCount)
+	h.DB.Model(&models.Channel{}).Where("kind = ?", models.ChannelKindChannel).Count(&stats.ChannelCount)
 	h.DB.Model(&models.ChannelMessage{}).Count(&stats.MessageCount)
 	h.DB.Model(&models.Attachment{}).Count(&stats.AttachmentCount)
 	h.DB.Model(&models.Attachment{}).Select("COALESCE(SUM(file_size), 0)").Scan(&stats.TotalFileSize)
@@ -222,13 +226,22 @@ func (h *AuthHandler) DeleteUser(c *fiber.Ctx) error {
 	}
 
 	// 删除用户相关的数据
-	// 1. 删除频道成员关系
+	// 1. 删除频道成员关系和表情回应
+	// 用户ID会被复用，先清除用户参与的私信的参与者标识，避免新用户取得同一ID后匹配到旧会话
+	h.DB.Exec("UPDATE channels SET direct_key = NULL WHERE kind = ? AND id IN (SELECT channel_id FROM channel_members WHERE user_id = ?)",
+		models.ChannelKindDirect, userId)
 	h.DB.Exec("DELETE FROM channel_members WHERE user_id = ?", userId)
+	h.DB.Exec("DELETE FROM reactions WHERE user_id = ?", userId)
+	h.DB.Exec("DELETE FROM channel_read_states WHERE user_id = ?", userId)
+	h.DB.Exec("DELETE FROM notifications WHERE user_id = ? OR actor_id = ?", userId, userId)
+	h.DB.Exec("DELETE FROM note_shares WHERE user_id = ?", userId)
+	// 删除用户的评论，话题被删除时其回复一并删除
+	h.DB.Exec("DELETE FROM note_comments WHERE user_id = ? OR parent_id IN (SELECT id FROM note_comments WHERE user_id = ?)", userId, userId)
 
 	// 2. 删除用户的笔记和相关附件
-	// 先获取该用户的所有笔记
+	// 先获取该用户的所有笔记，包括回收站中的笔记
 	var notes []models.Note
-	h.DB.Where("owner_id = ?", userId).Find(&notes)
+	h.DB.Unscoped().Where("owner_id = ?", userId).Find(&notes)
 
 	// 删除每个笔记的附件和文件
 	for _, note := range notes {
@@ -250,14 +263,27 @@ func (h *AuthHandler) DeleteUser(c *fiber.Ctx) error {
 		// 删除笔记目录
 		noteDir := filepath.Join("./data/uploads/notes", fmt.Sprintf("note_%d", note.ID))
 		os.RemoveAll(noteDir)
+
+		search.RemoveNote(h.DB, note.ID)
+		links.Remove(h.DB, note.ID)
+		deleteReactions(h.DB, models.ReactionTargetNote, note.ID)
+		h.DB.Exec("DELETE FROM notifications WHERE note_id = ?", note.ID)
+		h.DB.Exec("DELETE FROM note_shares WHERE note_id = ?", note.ID)
+		h.DB.Exec("DELETE FROM share_links WHERE note_id = ?", note.ID)
+		h.DB.Exec("DELETE FROM note_comments WHERE note_id = ?", note.ID)
+		// 删除协同文档和历史版本，避免ID被复用时恢复旧内容
+		h.Collab.DeleteDocument(note.ID)
+		h.DB.Exec("DELETE FROM note_revisions WHERE note_id = ?", note.ID)
 	}
 
-	// 删除用户的笔记
+	// 删除用户的笔记和个人文件夹
 	h.DB.Exec("DELETE FROM notes WHERE owner_id = ?", userId)
+	h.DB.Exec("DELETE FROM folders WHERE owner_id = ? AND channel_id IS NULL", userId)
 
 	// 3. 删除用户创建的频道（级联删除成员和消息）
+	// 私信的 owner_id 只是发起者，私信保留给其他参与者，上面已移除该用户的成员关系
 	var channels []models.Channel
-	h.DB.Where("owner_id = ?", userId).Find(&channels)
+	h.DB.Where("owner_id = ? AND kind = ?", userId, models.ChannelKindChannel).Find(&channels)
 
 	for _, channel := range channels {
 		// 删除频道目录
@@ -265,7 +291,7 @@ func (h *AuthHandler) DeleteUser(c *fiber.Ctx) error {
 		os.RemoveAll(channelDir)
 	}
 
-	h.DB.Exec("DELETE FROM channels WHERE owner_id = ?", userId)
+	h.DB.Exec("DELETE FROM channels WHERE owner_id = ? AND kind = ?", userId, models.ChannelKindChannel)
 
 	// 4. 删除用户
 	h.DB.Delete(&user)
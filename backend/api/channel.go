@@ -6,7 +6,10 @@ import (
 
 	"github.com/MiXiaoAi/oinote/backend/config"
 	"github.com/MiXiaoAi/oinote/backend/internal/models"
+	"github.com/MiXiaoAi/oinote/backend/internal/search"
+	"github.com/MiXiaoAi/oinote/backend/internal/websocket"
 	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
 )
 
 // CreateChannel 创建频道
@@ -19,9 +22,9 @@ func CreateChannel(c *fiber.Ctx) error {
 
 	input.OwnerID = userID
 
-	// 查找第一个可用的空ID（填充ID间隙）
+	// 查找第一个可用的空ID（填充ID间隙），回收站中的记录仍占用ID
 	var existingIDs []uint
-	config.DB.Model(&models.Channel{}).Order("id").Pluck("id", &existingIDs)
+	config.DB.Unscoped().Model(&models.Channel{}).Order("id").Pluck("id", &existingIDs)
 
 	nextAvailableID := uint(1)
 	for _, id := range existingIDs {
@@ -109,7 +112,7 @@ func GetUserChannels(c *fiber.Ctx) error {
 // GetAllChannels 管理员获取所有频道
 func GetAllChannels(c *fiber.Ctx) error {
 	var channels []models.Channel
-	config.DB.Preload("Owner").Find(&channels)
+	config.DB.Preload("Owner").Where("kind = ?", models.ChannelKindChannel).Find(&channels)
 
 	// 为每个频道添加成员数
 	for i := range channels {
@@ -146,7 +149,7 @@ func AdminToggleChannelPublic(c *fiber.Ctx) error {
 	}
 
 	var channel models.Channel
-	if err := config.DB.First(&channel, channelId).Error; err != nil {
+	if err := config.DB.Where("kind = ?", models.ChannelKindChannel).First(&channel, channelId).Error; err != nil {
 		return c.Status(404).JSON(fiber.Map{"error": "频道不存在"})
 	}
 
@@ -254,8 +257,7 @@ func (h *ChannelHandler) JoinChannelRequest(c *fiber.Ctx) error {
 			return c.Status(400).JSON(fiber.Map{"error": "您已经是该频道的成员"})
 		} else if existingMember.Status == models.MemberStatusInvited {
 			// 如果之前是被邀请状态，现在用户主动申请，更新为待审核状态
-			existingMember.Status = models.MemberStatusPending
-			if err := h.DB.Save(&existingMember).Error; err != nil {
+			if err := h.DB.Model(&existingMember).Update("status", models.MemberStatusPending).Error; err != nil {
 				return c.Status(500).JSON(fiber.Map{"error": "申请失败，请稍后重试"})
 			}
 			return c.JSON(fiber.Map{"message": "申请已提交"})
@@ -319,9 +321,7 @@ func (h *ChannelHandler) HandleMemberStatus(c *fiber.Ctx) error {
 		if memberRecord.Status != models.MemberStatusInvited {
 			return c.Status(400).SendString("状态错误")
 		}
-		memberRecord.Status = models.MemberStatusActive
-		memberRecord.JoinedAt = time.Now()
-		if err := h.DB.Save(&memberRecord).Error; err != nil {
+		if err := h.DB.Model(&memberRecord).Updates(joinedMemberUpdates(memberRecord.Role)).Error; err != nil {
 			return c.Status(500).JSON(fiber.Map{"error": "操作失败"})
 		}
 
@@ -331,7 +331,8 @@ func (h *ChannelHandler) HandleMemberStatus(c *fiber.Ctx) error {
 		// 广播频道更新事件，通知前端刷新成员状态
 		var channel models.Channel
 		h.DB.First(&channel, memberRecord.ChannelID)
-		h.Hub.BroadcastMessage("channel", "update", channel)
+		h.Hub.Publish(websocket.ToChannel(memberRecord.ChannelID).With(websocket.ToUsers(memberRecord.UserID)),
+			"channel", "update", channel)
 
 		return c.JSON(fiber.Map{"message": "已加入频道"})
 	}
@@ -346,10 +347,7 @@ func (h *ChannelHandler) HandleMemberStatus(c *fiber.Ctx) error {
 			return c.Status(403).SendString("无管理权限")
 		}
 
-		memberRecord.Status = models.MemberStatusActive
-		memberRecord.Role = models.RoleMember
-		memberRecord.JoinedAt = time.Now()
-		if err := h.DB.Save(&memberRecord).Error; err != nil {
+		if err := h.DB.Model(&memberRecord).Updates(joinedMemberUpdates(models.RoleMember)).Error; err != nil {
 			return c.Status(500).JSON(fiber.Map{"error": "操作失败"})
 		}
 
@@ -359,7 +357,8 @@ func (h *ChannelHandler) HandleMemberStatus(c *fiber.Ctx) error {
 		// 广播频道更新事件，通知前端刷新成员状态
 		var channel models.Channel
 		h.DB.First(&channel, memberRecord.ChannelID)
-		h.Hub.BroadcastMessage("channel", "update", channel)
+		h.Hub.Publish(websocket.ToChannel(memberRecord.ChannelID).With(websocket.ToUsers(memberRecord.UserID)),
+			"channel", "update", channel)
 
 		return c.JSON(fiber.Map{"message": "已批准加入"})
 	}
@@ -379,7 +378,8 @@ func (h *ChannelHandler) HandleMemberStatus(c *fiber.Ctx) error {
 		// 广播频道更新事件，通知前端刷新成员状态
 		var channel models.Channel
 		h.DB.First(&channel, memberRecord.ChannelID)
-		h.Hub.BroadcastMessage("channel", "update", channel)
+		h.Hub.Publish(websocket.ToChannel(memberRecord.ChannelID).With(websocket.ToUsers(memberRecord.UserID)),
+			"channel", "update", channel)
 		
 		return c.JSON(fiber.Map{"message": "已拒绝申请"})
 	}
@@ -387,6 +387,33 @@ func (h *ChannelHandler) HandleMemberStatus(c *fiber.Ctx) error {
 	return c.Status(400).SendString("未知操作")
 }
 
+// createSystemMessage 创建系统消息（UserID 为 0）
+// 与 CreateChannelMessage 一样用 datetime('now') 写入 created_at，消息分页和未读数按该格式比较；
+// user_id 有外键约束，系统消息存为 NULL，读取时为 0
+func (h *ChannelHandler) createSystemMessage(channelID uint, content string) (models.ChannelMessage, error) {
+	message := models.ChannelMessage{
+		ID:        nextMessageID(h.DB),
+		ChannelID: channelID,
+		Content:   content,
+	}
+	if err := h.DB.Exec("INSERT INTO channel_messages (id, created_at, updated_at, channel_id, user_id, content, is_highlighted, reply_count) VALUES (?, datetime('now'), datetime('now'), ?, NULL, ?, 0, 0)",
+		message.ID, message.ChannelID, message.Content).Error; err != nil {
+		return message, err
+	}
+	err := h.DB.Preload("User").First(&message, message.ID).Error
+	return message, err
+}
+
+// joinedMemberUpdates 成员加入频道时更新的字段
+// joined_at 与其他成员记录一样用 datetime('now') 写入，未读数按该格式与消息时间比较
+func joinedMemberUpdates(role string) map[string]interface{} {
+	return map[string]interface{}{
+		"status":    models.MemberStatusActive,
+		"role":      role,
+		"joined_at": gorm.Expr("datetime('now')"),
+	}
+}
+
 // sendWelcomeMessage 发送欢迎消息到频道
 func (h *ChannelHandler) sendWelcomeMessage(channelID uint, newUserID uint) {
 	// 获取新用户信息
@@ -396,17 +423,12 @@ func (h *ChannelHandler) sendWelcomeMessage(channelID uint, newUserID uint) {
 	}
 
 	// 创建系统欢迎消息
-	message := models.ChannelMessage{
-		ChannelID: channelID,
-		UserID:    0, // 0 表示系统消息
-		Content:   fmt.Sprintf("🎉 欢迎 %s 加入频道！", newUser.Nickname),
-	}
-
-	if err := h.DB.Create(&message).Error; err == nil {
-		h.DB.Preload("User").First(&message, message.ID)
+	message, err := h.createSystemMessage(channelID, fmt.Sprintf("🎉 欢迎 %s 加入频道！", newUser.Nickname))
+	if err == nil {
+		search.IndexMessage(h.DB, &message)
 
 		// 广播欢迎消息
-		h.Hub.BroadcastMessage("message", "create", message)
+		h.Hub.Publish(websocket.ToChannel(channelID), "message", "create", message)
 	}
 }
 
@@ -414,10 +436,11 @@ func (h *ChannelHandler) sendWelcomeMessage(channelID uint, newUserID uint) {
 func (h *ChannelHandler) GetPendingApprovals(c *fiber.Ctx) error {
 	userID := c.Locals("userId").(uint)
 
-	// 查找用户作为管理员或所有者的频道
+	// 查找用户作为管理员或所有者的频道（回收站中的频道不再处理申请和邀请）
 	var managedChannels []models.ChannelMember
 	result := h.DB.Where("user_id = ? AND status = ? AND (role = ? OR role = ?)",
 		userID, models.MemberStatusActive, models.RoleOwner, models.RoleAdmin).
+		Where("channel_id IN (?)", h.DB.Model(&models.Channel{}).Select("id")).
 		Preload("Channel").
 		Find(&managedChannels)
 
@@ -431,6 +454,7 @@ func (h *ChannelHandler) GetPendingApprovals(c *fiber.Ctx) error {
 		Preload("Channel").
 		Preload("Channel.Owner").
 		Where("user_id = ? AND status = ?", userID, models.MemberStatusInvited).
+		Where("channel_id IN (?)", h.DB.Model(&models.Channel{}).Select("id")).
 		Find(&userInvitations)
 
 	if result.Error != nil {
@@ -523,11 +547,7 @@ func (h *ChannelHandler) AcceptInvitation(c *fiber.Ctx) error {
 	}
 
 	// 更新状态为活跃
-	memberRecord.Status = models.MemberStatusActive
-	memberRecord.Role = models.RoleMember
-	memberRecord.JoinedAt = time.Now()
-
-	if err := h.DB.Save(&memberRecord).Error; err != nil {
+	if err := h.DB.Model(&memberRecord).Updates(joinedMemberUpdates(models.RoleMember)).Error; err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "接受邀请失败"})
 	}
 
@@ -537,7 +557,7 @@ func (h *ChannelHandler) AcceptInvitation(c *fiber.Ctx) error {
 	// 广播频道更新事件，通知前端刷新成员状态
 	var channel models.Channel
 	h.DB.First(&channel, memberRecord.ChannelID)
-	h.Hub.BroadcastMessage("channel", "update", channel)
+	h.Hub.Publish(websocket.ToChannel(memberRecord.ChannelID), "channel", "update", channel)
 
 	return c.JSON(fiber.Map{"message": "已接受邀请"})
 }
@@ -551,16 +571,11 @@ func (h *ChannelHandler) sendJoinMessage(channelID uint, newUserID uint) {
 	}
 
 	// 创建系统加入消息
-	message := models.ChannelMessage{
-		ChannelID: channelID,
-		UserID:    0, // 0 表示系统消息
-		Content:   fmt.Sprintf("🎉 %s 加入了频道！", newUser.Nickname),
-	}
-
-	if err := h.DB.Create(&message).Error; err == nil {
-		h.DB.Preload("User").First(&message, message.ID)
+	message, err := h.createSystemMessage(channelID, fmt.Sprintf("🎉 %s 加入了频道！", newUser.Nickname))
+	if err == nil {
+		search.IndexMessage(h.DB, &message)
 
 		// 广播加入消息
-		h.Hub.BroadcastMessage("message", "create", message)
+		h.Hub.Publish(websocket.ToChannel(channelID), "message", "create", message)
 	}
 }
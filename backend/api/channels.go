@@ -1,13 +1,15 @@
 package handlers
 
 import (
-	"fmt"
 	"os"
 	"path/filepath"
 	"strconv"
 	"time"
 
+	"github.com/MiXiaoAi/oinote/backend/internal/collab"
 	"github.com/MiXiaoAi/oinote/backend/internal/models"
+	"github.com/MiXiaoAi/oinote/backend/internal/notify"
+	"github.com/MiXiaoAi/oinote/backend/internal/search"
 	"github.com/MiXiaoAi/oinote/backend/internal/websocket"
 	"github.com/gofiber/fiber/v2"
 	"gorm.io/gorm"
@@ -95,12 +97,13 @@ func cleanupEmptyDirsSafe(dirPath string) {
 }
 
 type ChannelHandler struct {
-	DB   *gorm.DB
-	Hub  *websocket.Hub
+	DB     *gorm.DB
+	Hub    *websocket.Hub
+	Collab *collab.YjsServer
 }
 
-func NewChannelHandler(db *gorm.DB, hub *websocket.Hub) *ChannelHandler {
-	return &ChannelHandler{DB: db, Hub: hub}
+func NewChannelHandler(db *gorm.DB, hub *websocket.Hub, yjsServer *collab.YjsServer) *ChannelHandler {
+	return &ChannelHandler{DB: db, Hub: hub, Collab: yjsServer}
 }
 
 func (h *ChannelHandler) CreateChannel(c *fiber.Ctx) error {
@@ -112,9 +115,9 @@ func (h *ChannelHandler) CreateChannel(c *fiber.Ctx) error {
 
 	channel.OwnerID = userId
 
-	// 查找第一个可用的空ID（填充ID间隙）
+	// 查找第一个可用的空ID（填充ID间隙），回收站中的记录仍占用ID
 	var existingIDs []uint
-	h.DB.Model(&models.Channel{}).Order("id").Pluck("id", &existingIDs)
+	h.DB.Unscoped().Model(&models.Channel{}).Order("id").Pluck("id", &existingIDs)
 
 	nextAvailableID := uint(1)
 	for _, id := range existingIDs {
@@ -140,7 +143,7 @@ func (h *ChannelHandler) CreateChannel(c *fiber.Ctx) error {
 
 	// 检查是否有残留的消息记录
 	var messageCount int64
-	h.DB.Model(&models.ChannelMessage{}).Where("channel_id = ?", nextAvailableID).Count(&messageCount)
+	h.DB.Unscoped().Model(&models.ChannelMessage{}).Where("channel_id = ?", nextAvailableID).Count(&messageCount)
 	if messageCount > 0 {
 		// 有残留的消息记录，需要清理
 		h.DB.Exec("DELETE FROM channel_messages WHERE channel_id = ?", nextAvailableID)
@@ -185,7 +188,7 @@ func (h *ChannelHandler) CreateChannel(c *fiber.Ctx) error {
 	h.DB.Preload("Owner").First(&channel, channel.ID)
 
 	// 广播频道创建消息
-	h.Hub.BroadcastMessage("channel", "create", channel)
+	h.Hub.Publish(websocket.ToChannel(channel.ID), "channel", "create", channel)
 
 	return c.JSON(channel)
 }
@@ -262,10 +265,29 @@ func (h *ChannelHandler) GetPublicChannels(c *fiber.Ctx) error {
 func (h *ChannelHandler) GetUserChannels(c *fiber.Ctx) error {
 	userId := c.Locals("userId").(uint)
 	var channels []models.Channel
+	// 私信会话通过 /direct 单独获取
 	h.DB.Preload("Owner").Joins("JOIN channel_members ON channel_members.channel_id = channels.id").
-		Where("channel_members.user_id = ? AND channel_members.status = ?", userId, models.MemberStatusActive).
+		Where("channel_members.user_id = ? AND channel_members.status = ? AND channels.kind = ?",
+			userId, models.MemberStatusActive, models.ChannelKindChannel).
 		Find(&channels)
-	return c.JSON(channels)
+
+	// 附带每个频道的未读数和提及数
+	states := make(map[uint]UnreadState)
+	for _, state := range unreadStates(h.DB, "cm.user_id = ?", userId) {
+		states[state.ChannelID] = state
+	}
+
+	result := make([]ChannelWithUnread, 0, len(channels))
+	for _, channel := range channels {
+		state := states[channel.ID]
+		result = append(result, ChannelWithUnread{
+			Channel:           channel,
+			UnreadCount:       state.UnreadCount,
+			MentionCount:      state.MentionCount,
+			LastReadMessageID: state.LastReadMessageID,
+		})
+	}
+	return c.JSON(result)
 }
 
 // GetChannel 获取单个频道及其成员信息
@@ -282,11 +304,7 @@ func (h *ChannelHandler) GetChannel(c *fiber.Ctx) error {
 	if channel.IsPublic {
 		// 如果用户已登录且是频道成员，显示完整成员列表
 		if userId != nil {
-			var membership models.ChannelMember
-			err := h.DB.Where("channel_id = ? AND user_id = ? AND status = ?", channelId, userId, models.MemberStatusActive).
-				First(&membership).Error
-
-			if err == nil {
+			if models.IsChannelMember(h.DB, channel.ID, userId.(uint)) {
 				// 用户是成员，显示完整成员列表
 				var members []models.ChannelMember
 				h.DB.Preload("User").Where("channel_id = ? AND status = ?", channelId, models.MemberStatusActive).Find(&members)
@@ -308,9 +326,7 @@ func (h *ChannelHandler) GetChannel(c *fiber.Ctx) error {
 		return c.Status(403).JSON(fiber.Map{"error": "无权访问该频道"})
 	}
 
-	var membership models.ChannelMember
-	if err := h.DB.Where("channel_id = ? AND user_id = ? AND status = ?", channelId, userId, models.MemberStatusActive).
-		First(&membership).Error; err != nil {
+	if !models.IsChannelMember(h.DB, channel.ID, userId.(uint)) {
 		return c.Status(403).JSON(fiber.Map{"error": "无权访问该频道"})
 	}
 
@@ -324,6 +340,45 @@ func (h *ChannelHandler) GetChannel(c *fiber.Ctx) error {
 	})
 }
 
+// 频道消息分页
+const (
+	defaultMessageLimit = 50
+	maxMessageLimit     = 100
+)
+
+// nextMessageID 查找第一个可用的空消息ID（填充ID间隙），回收站中的记录仍占用ID
+func nextMessageID(db *gorm.DB) uint {
+	var existingIDs []uint
+	db.Unscoped().Model(&models.ChannelMessage{}).Order("id").Pluck("id", &existingIDs)
+
+	nextAvailableID := uint(1)
+	for _, id := range existingIDs {
+		if id != nextAvailableID {
+			break
+		}
+		nextAvailableID++
+	}
+	return nextAvailableID
+}
+
+// 消息游标条件，按 (created_at, id) 比较；ID 会填充间隙而被复用，不能单独作为时间顺序
+const (
+	messageCursorBefore = "(created_at, id) < (SELECT created_at, id FROM channel_messages WHERE id = ?)"
+	messageCursorAfter  = "(created_at, id) > (SELECT created_at, id FROM channel_messages WHERE id = ?)"
+	messageCursorFrom   = "(created_at, id) >= (SELECT created_at, id FROM channel_messages WHERE id = ?)"
+)
+
+// reverseMessages 反转消息顺序，按倒序查询后转为正序返回
+func reverseMessages(messages []models.ChannelMessage) []models.ChannelMessage {
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+	return messages
+}
+
+// GetChannelMessages 分页获取频道消息，结果按时间正序排列
+// before/after 为消息ID游标，分别获取该消息之前/之后的消息；around 获取某条消息前后的消息用于跳转定位；
+// 都不指定时返回最新的消息。highlighted=true 时只返回精华消息
 func (h *ChannelHandler) GetChannelMessages(c *fiber.Ctx) error {
 	channelId := c.Params("id")
 
@@ -339,20 +394,102 @@ func (h *ChannelHandler) GetChannelMessages(c *fiber.Ctx) error {
 			return c.Status(403).JSON(fiber.Map{"error": "无权访问该频道"})
 		}
 
-		var membership models.ChannelMember
-		if err := h.DB.Where("channel_id = ? AND user_id = ? AND status = ?", channelId, userId, models.MemberStatusActive).
-			First(&membership).Error; err != nil {
+		if !models.IsChannelMember(h.DB, channel.ID, userId.(uint)) {
 			return c.Status(403).JSON(fiber.Map{"error": "无权访问该频道"})
 		}
 	}
 
+	limit := c.QueryInt("limit", defaultMessageLimit)
+	if limit <= 0 || limit > maxMessageLimit {
+		limit = defaultMessageLimit
+	}
+	highlighted := c.QueryBool("highlighted", false)
+
+	// 话题回复不出现在频道消息列表中，通过首条消息的回复数展开
+	scope := func() *gorm.DB {
+		query := h.DB.Preload("User").Preload("Attachment").
+			Where("channel_id = ? AND parent_id IS NULL", channel.ID)
+		if highlighted {
+			query = query.Where("is_highlighted = ?", true)
+		}
+		return query
+	}
+
+	// 游标消息需要属于该频道，回收站中的消息也可以作为游标
+	cursor := func(name string) (uint, bool) {
+		id := c.QueryInt(name, 0)
+		if id <= 0 {
+			return 0, false
+		}
+		var count int64
+		h.DB.Unscoped().Model(&models.ChannelMessage{}).Where("id = ? AND channel_id = ?", id, channel.ID).Count(&count)
+		return uint(id), count > 0
+	}
+
 	var messages []models.ChannelMessage
-	h.DB.Preload("User").Preload("Attachment").
-		Where("channel_id = ?", channelId).
-		Order("created_at ASC").
-		Find(&messages)
+	switch {
+	case c.Query("around") != "":
+		id, ok := cursor("around")
+		if !ok {
+			return c.Status(404).JSON(fiber.Map{"error": "消息不存在"})
+		}
+		// 跳转到话题回复时定位到话题的首条消息
+		if root := h.threadRoot(channel.ID, id); root != nil {
+			id = root.ID
+		}
+		var older, newer []models.ChannelMessage
+		scope().Where(messageCursorBefore, id).Order("created_at DESC, id DESC").Limit(limit / 2).Find(&older)
+		scope().Where(messageCursorFrom, id).Order("created_at ASC, id ASC").Limit(limit - len(older)).Find(&newer)
+		messages = append(reverseMessages(older), newer...)
+
+	case c.Query("before") != "":
+		id, ok := cursor("before")
+		if !ok {
+			return c.Status(400).JSON(fiber.Map{"error": "无效的游标"})
+		}
+		scope().Where(messageCursorBefore, id).Order("created_at DESC, id DESC").Limit(limit).Find(&messages)
+		messages = reverseMessages(messages)
 
-	return c.JSON(messages)
+	case c.Query("after") != "":
+		id, ok := cursor("after")
+		if !ok {
+			return c.Status(400).JSON(fiber.Map{"error": "无效的游标"})
+		}
+		scope().Where(messageCursorAfter, id).Order("created_at ASC, id ASC").Limit(limit).Find(&messages)
+
+	default:
+		scope().Order("created_at DESC, id DESC").Limit(limit).Find(&messages)
+		messages = reverseMessages(messages)
+	}
+
+	if messages == nil {
+		messages = []models.ChannelMessage{}
+	}
+	attachMessageReactions(h.DB, messages, currentUserID(c))
+
+	// 当前页之前和之后是否还有消息，有则以首尾消息的ID作为下一页的游标
+	result := fiber.Map{
+		"messages":        messages,
+		"has_more_before": false,
+		"has_more_after":  false,
+		"before_cursor":   nil,
+		"after_cursor":    nil,
+	}
+	if len(messages) > 0 {
+		first, last := messages[0].ID, messages[len(messages)-1].ID
+		var count int64
+		if scope().Model(&models.ChannelMessage{}).Where(messageCursorBefore, first).Limit(1).Count(&count); count > 0 {
+			result["has_more_before"] = true
+			result["before_cursor"] = first
+		}
+		count = 0
+		if scope().Model(&models.ChannelMessage{}).Where(messageCursorAfter, last).Limit(1).Count(&count); count > 0 {
+			result["has_more_after"] = true
+			result["after_cursor"] = last
+		}
+	}
+
+	return c.JSON(result)
 }
 
 func (h *ChannelHandler) CreateChannelMessage(c *fiber.Ctx) error {
@@ -368,6 +505,7 @@ func (h *ChannelHandler) CreateChannelMessage(c *fiber.Ctx) error {
 	type Input struct {
 		Content      string `json:"content"`
 		AttachmentID *uint  `json:"attachment_id"`
+		ParentID     *uint  `json:"parent_id"` // 回复话题时为首条消息的ID
 	}
 
 	var input Input
@@ -379,31 +517,38 @@ func (h *ChannelHandler) CreateChannelMessage(c *fiber.Ctx) error {
 		return c.Status(400).JSON(fiber.Map{"error": "消息内容不能为空"})
 	}
 
-	// 查找第一个可用的空ID（填充ID间隙）
-	var existingIDs []uint
-	h.DB.Model(&models.ChannelMessage{}).Order("id").Pluck("id", &existingIDs)
+	// 通过 /messages/:messageId/replies 回复时以路径中的消息为准
+	if messageId := c.Params("messageId"); messageId != "" {
+		parentID, err := strconv.ParseUint(messageId, 10, 64)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "无效的消息ID"})
+		}
+		id := uint(parentID)
+		input.ParentID = &id
+	}
 
-	nextAvailableID := uint(1)
-	for _, id := range existingIDs {
-		if id == nextAvailableID {
-			nextAvailableID++
-		} else {
-			// 找到间隙
-			break
+	var parent *models.ChannelMessage
+	if input.ParentID != nil {
+		parent = h.threadRoot(membership.ChannelID, *input.ParentID)
+		if parent == nil {
+			return c.Status(404).JSON(fiber.Map{"error": "回复的消息不存在"})
 		}
 	}
 
 	message := models.ChannelMessage{
-		ID:           nextAvailableID,
+		ID:           nextMessageID(h.DB),
 		ChannelID:    membership.ChannelID,
 		UserID:       userId,
 		Content:      input.Content,
 		AttachmentID: input.AttachmentID,
 	}
+	if parent != nil {
+		message.ParentID = &parent.ID
+	}
 
 	// 使用Raw SQL插入，确保使用指定的ID
-	result := h.DB.Exec("INSERT INTO channel_messages (id, created_at, updated_at, channel_id, user_id, content, attachment_id, is_highlighted) VALUES (?, datetime('now'), datetime('now'), ?, ?, ?, ?, 0)",
-		message.ID, message.ChannelID, message.UserID, message.Content, message.AttachmentID)
+	result := h.DB.Exec("INSERT INTO channel_messages (id, created_at, updated_at, channel_id, user_id, content, attachment_id, is_highlighted, parent_id, reply_count) VALUES (?, datetime('now'), datetime('now'), ?, ?, ?, ?, 0, ?, 0)",
+		message.ID, message.ChannelID, message.UserID, message.Content, message.AttachmentID, message.ParentID)
 
 	if result.Error != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "发送消息失败"})
@@ -411,8 +556,26 @@ func (h *ChannelHandler) CreateChannelMessage(c *fiber.Ctx) error {
 
 	h.DB.Preload("User").Preload("Attachment").First(&message, message.ID)
 
+	search.IndexMessage(h.DB, &message)
+
+	// 发送消息即结束输入
+	h.Hub.TouchUser(userId)
+	h.Hub.SetTyping(message.ChannelID, userId, false)
+
+	// 通知被提及的用户，需在推送未读数之前创建以计入提及数
+	notify.MessageMentions(h.DB, h.Hub, &message, "")
+
+	if parent != nil {
+		// 话题回复单独广播，并更新首条消息的回复数
+		h.Hub.Publish(websocket.ToChannel(message.ChannelID), "message", "reply", message)
+		h.publishThreadStats(parent.ID)
+		publishUnread(h.DB, h.Hub, message.ChannelID, userId)
+		return c.JSON(message)
+	}
+
 	// 广播新消息到所有客户端
-	h.Hub.BroadcastMessage("message", "create", message)
+	h.Hub.Publish(websocket.ToChannel(message.ChannelID), "message", "create", message)
+	publishUnread(h.DB, h.Hub, message.ChannelID, userId)
 
 	return c.JSON(message)
 }
@@ -433,6 +596,14 @@ func (h *ChannelHandler) InviteUser(c *fiber.Ctx) error {
 		return c.Status(404).JSON(fiber.Map{"error": "用户不存在"})
 	}
 
+	var channel models.Channel
+	if err := h.DB.First(&channel, input.ChannelID).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "频道不存在"})
+	}
+	if channel.Kind == models.ChannelKindDirect {
+		return c.Status(400).JSON(fiber.Map{"error": "私信会话不能邀请成员"})
+	}
+
 	// 查找第一个可用的空ID（填充ID间隙）
 	var existingMemberIDs []uint
 	h.DB.Model(&models.ChannelMember{}).Order("id").Pluck("id", &existingMemberIDs)
@@ -498,6 +669,7 @@ func (h *ChannelHandler) RemoveMember(c *fiber.Ctx) error {
 	if err := h.DB.Delete(&targetMember).Error; err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "移出失败"})
 	}
+	h.DB.Where("channel_id = ? AND user_id = ?", targetMember.ChannelID, targetMember.UserID).Delete(&models.ChannelReadState{})
 
 	return c.JSON(fiber.Map{"message": "已移出成员"})
 }
@@ -572,7 +744,7 @@ func (h *ChannelHandler) UpdateChannel(c *fiber.Ctx) error {
 
 	var channel models.Channel
 	// Check if channel exists and user is owner
-	if err := h.DB.Where("id = ? AND owner_id = ?", channelId, userId).First(&channel).Error; err != nil {
+	if err := h.DB.Where("id = ? AND owner_id = ? AND kind = ?", channelId, userId, models.ChannelKindChannel).First(&channel).Error; err != nil {
 		return c.Status(404).JSON(fiber.Map{"error": "频道不存在或无权修改"})
 	}
 
@@ -592,7 +764,7 @@ func (h *ChannelHandler) UpdateChannel(c *fiber.Ctx) error {
 	h.DB.Preload("Owner").First(&channel, channel.ID)
 
 	// 广播频道更新消息
-	h.Hub.BroadcastMessage("channel", "update", channel)
+	h.Hub.Publish(websocket.ToChannel(channel.ID), "channel", "update", channel)
 
 	return c.JSON(channel)
 }
@@ -603,69 +775,48 @@ func (h *ChannelHandler) DeleteChannel(c *fiber.Ctx) error {
 
 	var channel models.Channel
 	// Check if channel exists and user is owner
-	if err := h.DB.Where("id = ? AND owner_id = ?", channelId, userId).First(&channel).Error; err != nil {
+	if err := h.DB.Where("id = ? AND owner_id = ? AND kind = ?", channelId, userId, models.ChannelKindChannel).First(&channel).Error; err != nil {
 		return c.Status(404).JSON(fiber.Map{"error": "频道不存在或无权删除"})
 	}
 
-	// Transaction to delete channel, members, notes and related data
-	err := h.DB.Transaction(func(tx *gorm.DB) error {
-		// 先统计要删除的成员数量（用于日志）
-		var memberCount int64
-		tx.Model(&models.ChannelMember{}).Where("channel_id = ?", channelId).Count(&memberCount)
-
-		// Delete channel members - 使用原生SQL确保彻底删除
-		if err := tx.Exec("DELETE FROM channel_members WHERE channel_id = ?", channelId).Error; err != nil {
-			return err
-		}
-
-		// Delete channel messages
-		if err := tx.Exec("DELETE FROM channel_messages WHERE channel_id = ?", channelId).Error; err != nil {
-			return err
-		}
+	// 先写回频道笔记的协同内容
+	var noteIDs []uint
+	h.DB.Model(&models.Note{}).Where("channel_id = ?", channel.ID).Pluck("id", &noteIDs)
+	for _, noteID := range noteIDs {
+		h.Collab.CloseDocument(noteID)
+	}
 
-		// Delete attachments
-		if err := tx.Exec("DELETE FROM attachments WHERE channel_id = ?", channelId).Error; err != nil {
+	// 频道连同其笔记和消息一起移入回收站，使用相同的删除时间以便整体恢复
+	deletedAt := time.Now()
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.Note{}).Where("channel_id = ?", channel.ID).Update("deleted_at", deletedAt).Error; err != nil {
 			return err
 		}
 
-		// Delete notes
-		if err := tx.Exec("DELETE FROM notes WHERE channel_id = ?", channelId).Error; err != nil {
+		if err := tx.Model(&models.ChannelMessage{}).Where("channel_id = ?", channel.ID).Update("deleted_at", deletedAt).Error; err != nil {
 			return err
 		}
 
-		// Delete channel
-		if err := tx.Exec("DELETE FROM channels WHERE id = ?", channelId).Error; err != nil {
+		if err := tx.Model(&models.Folder{}).Where("channel_id = ?", channel.ID).Update("deleted_at", deletedAt).Error; err != nil {
 			return err
 		}
 
-		// 验证删除是否成功
-		var remainingCount int64
-		tx.Model(&models.ChannelMember{}).Where("channel_id = ?", channelId).Count(&remainingCount)
-		if remainingCount > 0 {
-			return fmt.Errorf("删除后仍有 %d 条成员记录残留", remainingCount)
-		}
-
-		return nil
+		return tx.Model(&channel).Update("deleted_at", deletedAt).Error
 	})
 
-	if err == nil {
-		uploadDir := filepath.Join("./uploads", "channels", "channel_"+channelId)
-		os.RemoveAll(uploadDir)
-	}
-
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "删除频道失败"})
 	}
 
 	// 广播频道删除消息
-	h.Hub.BroadcastMessage("channel", "delete", fiber.Map{
+	h.Hub.Publish(websocket.ToChannel(channel.ID), "channel", "delete", fiber.Map{
 		"id": channelId,
 	})
 
 	return c.SendStatus(204)
 }
 
-// DeleteChannelMessage 删除消息（从数据库和文件系统中完全删除）
+// DeleteChannelMessage 删除消息（移入回收站）
 func (h *ChannelHandler) DeleteChannelMessage(c *fiber.Ctx) error {
 	userId := c.Locals("userId").(uint)
 	channelId := c.Params("id")
@@ -688,52 +839,128 @@ func (h *ChannelHandler) DeleteChannelMessage(c *fiber.Ctx) error {
 		return c.Status(403).JSON(fiber.Map{"error": "只能删除自己的消息"})
 	}
 
-	// 收集需要删除的附件信息
-	var attachmentToDelete *models.Attachment
-
-	// 开始事务删除消息和相关文件
+	// 软删除，附件保留到回收站清理时再删除
+	// 删除话题首条消息时回复一并移入回收站，使用相同的删除时间以便整体恢复
+	deletedAt := time.Now()
 	err := h.DB.Transaction(func(tx *gorm.DB) error {
-		// 如果消息有附件，标记为待删除
-		if message.AttachmentID != nil {
-			var attachment models.Attachment
-			if err := tx.First(&attachment, *message.AttachmentID).Error; err == nil {
-				attachmentToDelete = &attachment
-				// 删除附件记录（文件稍后删除）
-				tx.Delete(&attachment)
+		if message.ParentID == nil {
+			if err := tx.Model(&models.ChannelMessage{}).Where("parent_id = ?", message.ID).Update("deleted_at", deletedAt).Error; err != nil {
+				return err
 			}
 		}
-
-		// 删除消息记录
-		if err := tx.Delete(&message).Error; err != nil {
-			return err
-		}
-
-		return nil
+		return tx.Model(&message).Update("deleted_at", deletedAt).Error
 	})
-
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "删除消息失败"})
 	}
 
 	// 广播消息删除到所有客户端
-	h.Hub.BroadcastMessage("message", "delete", fiber.Map{
+	h.Hub.Publish(websocket.ToChannel(message.ChannelID), "message", "delete", fiber.Map{
 		"id":         message.ID,
 		"channel_id": message.ChannelID,
+		"parent_id":  message.ParentID,
 	})
+	if message.ParentID != nil {
+		h.publishThreadStats(*message.ParentID)
+	}
+
+	return c.JSON(fiber.Map{"message": "消息已删除"})
+}
 
-	// 延迟删除文件（等待浏览器释放引用）
-	if attachmentToDelete != nil && attachmentToDelete.FilePath != "" {
-		cwd, _ := os.Getwd()
-		fullPath := filepath.Join(cwd, "data", attachmentToDelete.FilePath)
-		absPath, _ := filepath.Abs(fullPath)
+// UpdateChannelMessage 编辑频道消息（仅发送者），编辑前的内容保存到编辑历史
+func (h *ChannelHandler) UpdateChannelMessage(c *fiber.Ctx) error {
+	userId := c.Locals("userId").(uint)
+	channelId := c.Params("id")
+	messageId := c.Params("messageId")
 
-		go func() {
-			time.Sleep(5 * time.Second)
-			os.Remove(absPath)
-		}()
+	if !models.IsChannelMember(h.DB, paramChannelID(c), userId) {
+		return c.Status(403).JSON(fiber.Map{"error": "无权访问该频道"})
 	}
 
-	return c.JSON(fiber.Map{"message": "消息已删除"})
+	var message models.ChannelMessage
+	if err := h.DB.Where("id = ? AND channel_id = ?", messageId, channelId).First(&message).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "消息不存在"})
+	}
+
+	if message.UserID != userId {
+		return c.Status(403).JSON(fiber.Map{"error": "只能编辑自己的消息"})
+	}
+
+	var input struct {
+		Content string `json:"content"`
+	}
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "输入数据无效"})
+	}
+
+	// 带附件的消息可以清空文字，纯文字消息不能编辑为空
+	if input.Content == "" && message.AttachmentID == nil {
+		return c.Status(400).JSON(fiber.Map{"error": "消息内容不能为空"})
+	}
+
+	oldContent := message.Content
+	if input.Content != message.Content {
+		editedAt := time.Now()
+		err := h.DB.Transaction(func(tx *gorm.DB) error {
+			edit := models.ChannelMessageEdit{
+				MessageID: message.ID,
+				ChannelID: message.ChannelID,
+				Content:   message.Content,
+				EditorID:  userId,
+			}
+			if err := tx.Create(&edit).Error; err != nil {
+				return err
+			}
+			return tx.Model(&message).Updates(map[string]interface{}{
+				"content":   input.Content,
+				"edited_at": editedAt,
+			}).Error
+		})
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "编辑消息失败"})
+		}
+	}
+
+	h.DB.Preload("User").Preload("Attachment").First(&message, message.ID)
+
+	search.IndexMessage(h.DB, &message)
+	notify.MessageMentions(h.DB, h.Hub, &message, oldContent)
+
+	// 广播消息更新到所有客户端
+	h.Hub.Publish(websocket.ToChannel(message.ChannelID), "message", "update", message)
+
+	return c.JSON(message)
+}
+
+// GetChannelMessageHistory 获取消息的编辑历史，供频道管理员审核，发送者也可以查看自己的消息
+func (h *ChannelHandler) GetChannelMessageHistory(c *fiber.Ctx) error {
+	userId := c.Locals("userId").(uint)
+	channelId := c.Params("id")
+	messageId := c.Params("messageId")
+
+	var membership models.ChannelMember
+	if err := h.DB.Where("channel_id = ? AND user_id = ? AND status = ?", channelId, userId, models.MemberStatusActive).
+		First(&membership).Error; err != nil {
+		return c.Status(403).JSON(fiber.Map{"error": "无权访问该频道"})
+	}
+
+	// 回收站中的消息同样可以查看编辑历史
+	var message models.ChannelMessage
+	if err := h.DB.Unscoped().Preload("User").Where("id = ? AND channel_id = ?", messageId, channelId).First(&message).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "消息不存在"})
+	}
+
+	if message.UserID != userId && membership.Role != models.RoleAdmin && membership.Role != models.RoleOwner {
+		return c.Status(403).JSON(fiber.Map{"error": "无权查看编辑历史"})
+	}
+
+	var edits []models.ChannelMessageEdit
+	h.DB.Preload("Editor").Where("message_id = ?", message.ID).Order("created_at DESC, id DESC").Find(&edits)
+
+	return c.JSON(fiber.Map{
+		"message": message,
+		"edits":   edits,
+	})
 }
 
 // HighlightMessage 设置或取消精华消息
@@ -754,14 +981,14 @@ func (h *ChannelHandler) HighlightMessage(c *fiber.Ctx) error {
 		return c.Status(404).JSON(fiber.Map{"error": "消息不存在"})
 	}
 
-	// 切换精华状态
+	// 切换精华状态，只更新该字段，避免改写创建时间的存储格式而影响消息排序
 	message.IsHighlighted = !message.IsHighlighted
-	if err := h.DB.Save(&message).Error; err != nil {
+	if err := h.DB.Model(&message).Update("is_highlighted", message.IsHighlighted).Error; err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "设置精华失败"})
 	}
 
 	// 广播精华状态更新到所有客户端
-	h.Hub.BroadcastMessage("message", "highlight", fiber.Map{
+	h.Hub.Publish(websocket.ToChannel(message.ChannelID), "message", "highlight", fiber.Map{
 		"id":              message.ID,
 		"channel_id":      message.ChannelID,
 		"is_highlighted":  message.IsHighlighted,
@@ -777,3 +1004,12 @@ func (h *ChannelHandler) HighlightMessage(c *fiber.Ctx) error {
 		"is_highlighted": message.IsHighlighted,
 	})
 }
+
+// paramChannelID 路由参数中的频道ID，无效时为 0
+func paramChannelID(c *fiber.Ctx) uint {
+	id, err := c.ParamsInt("id")
+	if err != nil || id < 0 {
+		return 0
+	}
+	return uint(id)
+}
@@ -0,0 +1,316 @@
+package handlers
+
+import (
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"github.com/MiXiaoAi/oinote/backend/internal/collab"
+	"github.com/MiXiaoAi/oinote/backend/internal/models"
+	"github.com/MiXiaoAi/oinote/backend/internal/search"
+	"github.com/MiXiaoAi/oinote/backend/internal/websocket"
+	"github.com/gofiber/fiber/v2"
+)
+
+// 评论引用文字的最大字数
+const maxCommentQuoteRunes = 200
+
+// canCommentNote 可以编辑笔记的用户和被共享为评论者的用户可以评论
+func (h *NoteHandler) canCommentNote(note *models.Note, userId uint) bool {
+	if h.canManageNote(note, userId) {
+		return true
+	}
+	role := models.NoteShareRole(h.DB, note.ID, userId)
+	return role == models.ShareRoleCommenter || role == models.ShareRoleEditor
+}
+
+// utf16Slice 按 UTF-16 索引截取字符串，与 Y.Text 的索引一致
+func utf16Slice(s string, from, to int) string {
+	units := utf16.Encode([]rune(s))
+	if to > len(units) {
+		to = len(units)
+	}
+	if from < 0 || from >= to {
+		return ""
+	}
+	return string(utf16.Decode(units[from:to]))
+}
+
+// commentQuote 取锚定范围内的文字作为评论引用，去除 HTML 标签
+func commentQuote(content string, from, to int) string {
+	quote := []rune(search.StripHTML(utf16Slice(content, from, to)))
+	if len(quote) > maxCommentQuoteRunes {
+		return string(quote[:maxCommentQuoteRunes]) + "…"
+	}
+	return string(quote)
+}
+
+// resolveCommentAnchor 计算评论话题锚点在当前内容中的位置
+func (h *NoteHandler) resolveCommentAnchor(note *models.Note, comment *models.NoteComment) {
+	if len(comment.AnchorStart) == 0 {
+		return
+	}
+	setCommentRange(h.Collab.ReadDocument(note.ID), comment)
+}
+
+// setCommentRange 按协同文档计算评论话题锚点的位置，笔记没有协同数据时不设置
+func setCommentRange(doc *collab.YjsDocument, comment *models.NoteComment) {
+	if len(comment.AnchorStart) == 0 {
+		return
+	}
+	if from, to, ok := doc.ResolveAnchor(comment.AnchorStart, comment.AnchorEnd); ok {
+		comment.From, comment.To = &from, &to
+	}
+}
+
+// loadNoteComment 加载笔记的一条评论
+func (h *NoteHandler) loadNoteComment(c *fiber.Ctx, noteID uint) (*models.NoteComment, error) {
+	var comment models.NoteComment
+	if err := h.DB.Preload("User").Where("id = ? AND note_id = ?", c.Params("commentId"), noteID).First(&comment).Error; err != nil {
+		return nil, c.Status(404).JSON(fiber.Map{"error": "评论不存在"})
+	}
+	return &comment, nil
+}
+
+// loadCommentableNote 加载笔记并检查当前用户可以查看其评论
+func (h *NoteHandler) loadCommentableNote(c *fiber.Ctx) (*models.Note, error) {
+	var note models.Note
+	if err := h.DB.First(&note, "id = ?", c.Params("id")).Error; err != nil {
+		return nil, c.Status(404).JSON(fiber.Map{"error": "笔记不存在"})
+	}
+	if !models.CanViewNote(h.DB, &note, currentUserID(c)) {
+		return nil, c.Status(403).JSON(fiber.Map{"error": "无权访问该笔记"})
+	}
+	return &note, nil
+}
+
+// GetNoteComments 获取笔记的评论话题及其回复，resolved=true/false 按是否已解决筛选
+func (h *NoteHandler) GetNoteComments(c *fiber.Ctx) error {
+	note, err := h.loadCommentableNote(c)
+	if note == nil {
+		return err
+	}
+
+	query := h.DB.Preload("User").Where("note_id = ? AND parent_id IS NULL", note.ID)
+	switch c.Query("resolved") {
+	case "true":
+		query = query.Where("resolved_at IS NOT NULL")
+	case "false":
+		query = query.Where("resolved_at IS NULL")
+	}
+
+	threads := []models.NoteComment{}
+	query.Order("id").Find(&threads)
+	if len(threads) == 0 {
+		return c.JSON(threads)
+	}
+
+	threadIDs := make([]uint, len(threads))
+	for i, thread := range threads {
+		threadIDs[i] = thread.ID
+	}
+	var replies []models.NoteComment
+	h.DB.Preload("User").Where("parent_id IN ?", threadIDs).Order("id").Find(&replies)
+	repliesByThread := make(map[uint][]models.NoteComment)
+	for _, reply := range replies {
+		repliesByThread[*reply.ParentID] = append(repliesByThread[*reply.ParentID], reply)
+	}
+
+	// 协同文档只读取一次，未加载时临时从数据库恢复，不会常驻内存
+	doc := h.Collab.ReadDocument(note.ID)
+	for i := range threads {
+		threads[i].Replies = repliesByThread[threads[i].ID]
+		setCommentRange(doc, &threads[i])
+	}
+
+	return c.JSON(threads)
+}
+
+// CreateNoteComment 创建评论话题或回复
+// 话题的锚点可以是客户端生成的 Yjs 相对位置（anchor_start、anchor_end，base64 编码），
+// 也可以是内容中的 UTF-16 索引范围（from、to），由服务器转换为相对位置；都省略时评论整篇笔记
+func (h *NoteHandler) CreateNoteComment(c *fiber.Ctx) error {
+	userId := c.Locals("userId").(uint)
+
+	var note models.Note
+	if err := h.DB.First(&note, c.Params("id")).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "笔记不存在"})
+	}
+	if !h.canCommentNote(&note, userId) {
+		return c.Status(403).JSON(fiber.Map{"error": "无权评论该笔记"})
+	}
+
+	var input struct {
+		Content     string `json:"content"`
+		ParentID    *uint  `json:"parent_id"`
+		AnchorStart []byte `json:"anchor_start"`
+		AnchorEnd   []byte `json:"anchor_end"`
+		From        *int   `json:"from"`
+		To          *int   `json:"to"`
+		Quote       string `json:"quote"`
+	}
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "输入数据无效"})
+	}
+
+	input.Content = strings.TrimSpace(input.Content)
+	if input.Content == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "评论内容不能为空"})
+	}
+
+	comment := models.NoteComment{
+		NoteID:   note.ID,
+		UserID:   userId,
+		ParentID: input.ParentID,
+		Content:  input.Content,
+	}
+
+	if input.ParentID != nil {
+		// 回复只有一层，锚点跟随所属的话题
+		var parent models.NoteComment
+		if err := h.DB.Where("id = ? AND note_id = ? AND parent_id IS NULL", *input.ParentID, note.ID).First(&parent).Error; err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": "评论不存在"})
+		}
+	} else if len(input.AnchorStart) > 0 || len(input.AnchorEnd) > 0 {
+		if _, _, ok := h.Collab.ResolveAnchor(note.ID, input.AnchorStart, input.AnchorEnd); !ok {
+			return c.Status(400).JSON(fiber.Map{"error": "无效的评论锚点"})
+		}
+		comment.AnchorStart, comment.AnchorEnd = input.AnchorStart, input.AnchorEnd
+	} else if input.From != nil || input.To != nil {
+		if input.From == nil || input.To == nil {
+			return c.Status(400).JSON(fiber.Map{"error": "无效的评论锚点"})
+		}
+		start, end, ok := h.Collab.CreateAnchor(note.ID, note.Content, *input.From, *input.To)
+		if !ok {
+			return c.Status(400).JSON(fiber.Map{"error": "无效的评论锚点"})
+		}
+		comment.AnchorStart, comment.AnchorEnd = start, end
+	}
+
+	if comment.ParentID == nil {
+		comment.Quote = strings.TrimSpace(input.Quote)
+		h.resolveCommentAnchor(&note, &comment)
+		if comment.Quote == "" && comment.From != nil {
+			comment.Quote = commentQuote(h.Collab.GetDocumentContent(note.ID), *comment.From, *comment.To)
+		}
+	}
+
+	if err := h.DB.Create(&comment).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "创建评论失败"})
+	}
+	h.DB.Preload("User").First(&comment, comment.ID)
+	h.resolveCommentAnchor(&note, &comment)
+
+	h.Hub.Publish(websocket.ToNote(note.ID), "comment", "create", comment)
+
+	return c.Status(201).JSON(comment)
+}
+
+// UpdateNoteComment 修改自己的评论内容
+func (h *NoteHandler) UpdateNoteComment(c *fiber.Ctx) error {
+	userId := c.Locals("userId").(uint)
+	note, err := h.loadCommentableNote(c)
+	if note == nil {
+		return err
+	}
+	comment, err := h.loadNoteComment(c, note.ID)
+	if comment == nil {
+		return err
+	}
+	if comment.UserID != userId {
+		return c.Status(403).JSON(fiber.Map{"error": "只能修改自己的评论"})
+	}
+
+	var input struct {
+		Content string `json:"content"`
+	}
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "输入数据无效"})
+	}
+	input.Content = strings.TrimSpace(input.Content)
+	if input.Content == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "评论内容不能为空"})
+	}
+
+	if err := h.DB.Model(comment).Update("content", input.Content).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "修改评论失败"})
+	}
+	h.resolveCommentAnchor(note, comment)
+
+	h.Hub.Publish(websocket.ToNote(note.ID), "comment", "update", comment)
+
+	return c.JSON(comment)
+}
+
+// ResolveNoteComment 把评论话题标记为已解决
+func (h *NoteHandler) ResolveNoteComment(c *fiber.Ctx) error {
+	return h.setCommentResolved(c, true)
+}
+
+// ReopenNoteComment 重新打开已解决的评论话题
+func (h *NoteHandler) ReopenNoteComment(c *fiber.Ctx) error {
+	return h.setCommentResolved(c, false)
+}
+
+// setCommentResolved 解决或重新打开评论话题，话题作者和可以编辑笔记的用户可以操作
+func (h *NoteHandler) setCommentResolved(c *fiber.Ctx, resolved bool) error {
+	userId := c.Locals("userId").(uint)
+	note, err := h.loadCommentableNote(c)
+	if note == nil {
+		return err
+	}
+	comment, err := h.loadNoteComment(c, note.ID)
+	if comment == nil {
+		return err
+	}
+	if comment.ParentID != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "只能解决评论话题，不能解决回复"})
+	}
+	if comment.UserID != userId && !h.canEditNote(note, userId) {
+		return c.Status(403).JSON(fiber.Map{"error": "无权修改该评论"})
+	}
+
+	action := "reopen"
+	updates := map[string]interface{}{"resolved_at": nil, "resolved_by": nil}
+	if resolved {
+		action = "resolve"
+		updates = map[string]interface{}{"resolved_at": time.Now(), "resolved_by": userId}
+	}
+	if err := h.DB.Model(comment).Updates(updates).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "修改评论失败"})
+	}
+	h.DB.Preload("User").First(comment, comment.ID)
+	h.resolveCommentAnchor(note, comment)
+
+	h.Hub.Publish(websocket.ToNote(note.ID), "comment", action, comment)
+
+	return c.JSON(comment)
+}
+
+// DeleteNoteComment 删除评论，删除话题时其回复一并删除；评论作者和笔记管理者可以删除
+func (h *NoteHandler) DeleteNoteComment(c *fiber.Ctx) error {
+	userId := c.Locals("userId").(uint)
+	note, err := h.loadCommentableNote(c)
+	if note == nil {
+		return err
+	}
+	comment, err := h.loadNoteComment(c, note.ID)
+	if comment == nil {
+		return err
+	}
+	if comment.UserID != userId && !h.canManageNote(note, userId) {
+		return c.Status(403).JSON(fiber.Map{"error": "无权删除该评论"})
+	}
+
+	if err := h.DB.Where("id = ? OR parent_id = ?", comment.ID, comment.ID).Delete(&models.NoteComment{}).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "删除评论失败"})
+	}
+
+	h.Hub.Publish(websocket.ToNote(note.ID), "comment", "delete", fiber.Map{
+		"id":        comment.ID,
+		"note_id":   note.ID,
+		"parent_id": comment.ParentID,
+	})
+
+	return c.SendStatus(204)
+}
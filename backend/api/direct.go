@@ -0,0 +1,247 @@
+package handlers
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/MiXiaoAi/oinote/backend/internal/models"
+	"github.com/MiXiaoAi/oinote/backend/internal/websocket"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// 私信会话最多的参与人数（包括自己）
+const maxDirectParticipants = 10
+
+// DirectConversation 私信会话，附带参与者、最后一条消息和未读数
+type DirectConversation struct {
+	ChannelWithUnread
+	Participants []models.User          `json:"participants"`
+	LastMessage  *models.ChannelMessage `json:"last_message"`
+}
+
+// directKey 生成参与者集合的唯一标识
+func directKey(userIDs []uint) string {
+	ids := make([]string, len(userIDs))
+	for i, id := range userIDs {
+		ids[i] = strconv.FormatUint(uint64(id), 10)
+	}
+	return strings.Join(ids, ",")
+}
+
+// nextChannelID 查找第一个可用的空频道ID（填充ID间隙），回收站中的记录仍占用ID
+func nextChannelID(tx *gorm.DB) uint {
+	var existingIDs []uint
+	tx.Unscoped().Model(&models.Channel{}).Order("id").Pluck("id", &existingIDs)
+
+	nextAvailableID := uint(1)
+	for _, id := range existingIDs {
+		if id != nextAvailableID {
+			break
+		}
+		nextAvailableID++
+	}
+	return nextAvailableID
+}
+
+// loadDirectConversations 加载用户的私信会话，按最近活动时间倒序
+func (h *ChannelHandler) loadDirectConversations(userId uint, channelIDs ...uint) []DirectConversation {
+	query := h.DB.Joins("JOIN channel_members ON channel_members.channel_id = channels.id").
+		Where("channels.kind = ? AND channel_members.user_id = ? AND channel_members.status = ?",
+			models.ChannelKindDirect, userId, models.MemberStatusActive)
+	if len(channelIDs) > 0 {
+		query = query.Where("channels.id IN ?", channelIDs)
+	}
+	var channels []models.Channel
+	query.Preload("Owner").Find(&channels)
+
+	states := make(map[uint]UnreadState)
+	for _, state := range unreadStates(h.DB, "cm.user_id = ?", userId) {
+		states[state.ChannelID] = state
+	}
+
+	conversations := make([]DirectConversation, 0, len(channels))
+	for _, channel := range channels {
+		conversation := DirectConversation{
+			ChannelWithUnread: ChannelWithUnread{Channel: channel},
+			Participants:      []models.User{},
+		}
+		if state, ok := states[channel.ID]; ok {
+			conversation.UnreadCount = state.UnreadCount
+			conversation.MentionCount = state.MentionCount
+			conversation.LastReadMessageID = state.LastReadMessageID
+		}
+
+		h.DB.Joins("JOIN channel_members ON channel_members.user_id = users.id").
+			Where("channel_members.channel_id = ? AND channel_members.status = ?", channel.ID, models.MemberStatusActive).
+			Order("users.id").Find(&conversation.Participants)
+
+		var lastMessage models.ChannelMessage
+		if err := h.DB.Preload("User").Preload("Attachment").Where("channel_id = ? AND parent_id IS NULL", channel.ID).
+			Order("created_at DESC, id DESC").First(&lastMessage).Error; err == nil {
+			conversation.LastMessage = &lastMessage
+		}
+		conversations = append(conversations, conversation)
+	}
+
+	sort.SliceStable(conversations, func(i, j int) bool {
+		return conversations[i].activeAt().After(conversations[j].activeAt())
+	})
+	return conversations
+}
+
+// activeAt 会话的最近活动时间，没有消息时为创建时间
+func (d *DirectConversation) activeAt() time.Time {
+	if d.LastMessage != nil {
+		return d.LastMessage.CreatedAt
+	}
+	return d.CreatedAt
+}
+
+// GetDirectConversations 获取当前用户的私信会话列表
+func (h *ChannelHandler) GetDirectConversations(c *fiber.Ctx) error {
+	userId := c.Locals("userId").(uint)
+	return c.JSON(h.loadDirectConversations(userId))
+}
+
+// CreateDirectConversation 与一个或多个用户开始私信，同一组参与者重复调用返回已有的会话
+func (h *ChannelHandler) CreateDirectConversation(c *fiber.Ctx) error {
+	userId := c.Locals("userId").(uint)
+
+	var input struct {
+		UserIDs   []uint   `json:"user_ids"`
+		Usernames []string `json:"usernames"`
+		Name      string   `json:"name"` // 群聊名称，可选
+	}
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "输入数据无效"})
+	}
+
+	// 参与者可以通过用户ID或用户名指定，自己总是参与者
+	participants := map[uint]bool{userId: true}
+	var users []models.User
+	if len(input.UserIDs) > 0 {
+		h.DB.Where("id IN ?", input.UserIDs).Find(&users)
+		if len(users) != len(uniqueIDs(input.UserIDs)) {
+			return c.Status(404).JSON(fiber.Map{"error": "用户不存在"})
+		}
+		for _, user := range users {
+			participants[user.ID] = true
+		}
+	}
+	if len(input.Usernames) > 0 {
+		users = nil
+		h.DB.Where("username IN ?", input.Usernames).Find(&users)
+		if len(users) != len(uniqueStrings(input.Usernames)) {
+			return c.Status(404).JSON(fiber.Map{"error": "用户不存在"})
+		}
+		for _, user := range users {
+			participants[user.ID] = true
+		}
+	}
+
+	if len(participants) < 2 {
+		return c.Status(400).JSON(fiber.Map{"error": "请选择私信对象"})
+	}
+	if len(participants) > maxDirectParticipants {
+		return c.Status(400).JSON(fiber.Map{"error": "私信人数超过上限"})
+	}
+
+	userIDs := make([]uint, 0, len(participants))
+	for id := range participants {
+		userIDs = append(userIDs, id)
+	}
+	sort.Slice(userIDs, func(i, j int) bool { return userIDs[i] < userIDs[j] })
+	key := directKey(userIDs)
+
+	var channel models.Channel
+	created := false
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("direct_key = ?", key).First(&channel).Error; err == nil {
+			// 用户ID会被复用，参与者不全是会话成员时说明是已删除用户留下的旧会话，不再复用
+			var memberCount int64
+			tx.Model(&models.ChannelMember{}).
+				Where("channel_id = ? AND user_id IN ? AND status = ?", channel.ID, userIDs, models.MemberStatusActive).
+				Count(&memberCount)
+			if int(memberCount) == len(userIDs) {
+				return nil
+			}
+			if err := tx.Model(&channel).Update("direct_key", nil).Error; err != nil {
+				return err
+			}
+			channel = models.Channel{}
+		}
+
+		channelID := nextChannelID(tx)
+		// 复用ID时清理旧频道残留的成员和消息
+		if err := tx.Exec("DELETE FROM channel_members WHERE channel_id = ?", channelID).Error; err != nil {
+			return err
+		}
+		if err := tx.Exec("DELETE FROM channel_messages WHERE channel_id = ?", channelID).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Exec("INSERT INTO channels (id, created_at, updated_at, name, description, owner_id, is_public, theme_color, tags, kind, direct_key) VALUES (?, datetime('now'), datetime('now'), ?, '', ?, ?, '', '', ?, ?)",
+			channelID, input.Name, userId, false, models.ChannelKindDirect, key).Error; err != nil {
+			return err
+		}
+
+		// 私信没有所有者和管理员，所有参与者都是普通成员
+		for _, participantID := range userIDs {
+			if err := tx.Exec("INSERT INTO channel_members (channel_id, user_id, role, status, joined_at) VALUES (?, ?, ?, ?, datetime('now'))",
+				channelID, participantID, models.RoleMember, models.MemberStatusActive).Error; err != nil {
+				return err
+			}
+		}
+
+		created = true
+		return tx.First(&channel, channelID).Error
+	})
+	if err != nil {
+		// 并发创建同一组参与者的会话时唯一索引冲突，返回已创建的会话
+		created = false
+		if h.DB.Where("direct_key = ?", key).First(&channel).Error != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "创建私信失败"})
+		}
+	}
+
+	conversations := h.loadDirectConversations(userId, channel.ID)
+	if len(conversations) == 0 {
+		return c.Status(500).JSON(fiber.Map{"error": "创建私信失败"})
+	}
+	conversation := conversations[0]
+
+	if created {
+		h.Hub.Publish(websocket.ToUsers(userIDs...), "direct", "create", conversation)
+		return c.Status(201).JSON(conversation)
+	}
+	return c.JSON(conversation)
+}
+
+// uniqueIDs 去除重复的ID
+func uniqueIDs(ids []uint) []uint {
+	seen := make(map[uint]bool)
+	var result []uint
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			result = append(result, id)
+		}
+	}
+	return result
+}
+
+// uniqueStrings 去除重复的字符串
+func uniqueStrings(values []string) []string {
+	seen := make(map[string]bool)
+	var result []string
+	for _, value := range values {
+		if !seen[value] {
+			seen[value] = true
+			result = append(result, value)
+		}
+	}
+	return result
+}
@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/MiXiaoAi/oinote/backend/internal/websocket"
+	"github.com/gofiber/fiber/v2"
+)
+
+// SSE 心跳间隔，防止代理因连接空闲而断开
+const eventsHeartbeatInterval = 30 * time.Second
+
+type EventsHandler struct {
+	Hub *websocket.Hub
+}
+
+func NewEventsHandler(hub *websocket.Hub) *EventsHandler {
+	return &EventsHandler{Hub: hub}
+}
+
+// parseEventID 解析事件ID，格式为 纪元-序号
+func parseEventID(id string) (string, uint64, bool) {
+	i := strings.LastIndex(id, "-")
+	if i <= 0 {
+		return "", 0, false
+	}
+	seq, err := strconv.ParseUint(id[i+1:], 10, 64)
+	if err != nil {
+		return "", 0, false
+	}
+	return id[:i], seq, true
+}
+
+// Stream 以 Server-Sent Events 推送实时事件
+// 事件内容和接收范围与 WebSocket 相同；断线重连时浏览器会带上 Last-Event-ID，
+// 首次连接也可以通过 lastEventId 查询参数指定，据此补发错过的事件
+func (h *EventsHandler) Stream(c *fiber.Ctx) error {
+	userId := c.Locals("userId").(uint)
+
+	client := &websocket.Client{
+		ID:     fmt.Sprintf("sse-%d-%d", userId, time.Now().UnixNano()),
+		Send:   make(chan []byte, 256),
+		UserID: userId,
+	}
+	lastEventID := c.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = c.Query("lastEventId")
+	}
+	if epoch, seq, ok := parseEventID(lastEventID); ok {
+		client.Resume = true
+		client.Since = seq
+		client.Epoch = epoch
+	}
+
+	c.Set("Content-Type", "text/event-stream")
+	c.Set("Cache-Control", "no-cache")
+	c.Set("Connection", "keep-alive")
+	// 关闭 nginx 等反向代理的响应缓冲
+	c.Set("X-Accel-Buffering", "no")
+
+	hub := h.Hub
+	epoch := hub.Epoch()
+	hub.Register(client)
+
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer hub.Unregister(client)
+
+		ticker := time.NewTicker(eventsHeartbeatInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case data, ok := <-client.Send:
+				if !ok {
+					return
+				}
+				if id := eventID(epoch, data); id != "" {
+					fmt.Fprintf(w, "id: %s\n", id)
+				}
+				fmt.Fprintf(w, "data: %s\n\n", data)
+			case <-ticker.C:
+				w.WriteString(": ping\n\n")
+			}
+			// 客户端断开后写入失败
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+	})
+
+	return nil
+}
+
+// eventID 生成 SSE 事件ID，浏览器重连时原样放在 Last-Event-ID 中
+// 连接确认消息本身没有序号，续传成功时沿用客户端原来的位置，否则从当前序号开始
+func eventID(epoch string, data []byte) string {
+	var message websocket.Message
+	if err := json.Unmarshal(data, &message); err != nil {
+		return ""
+	}
+	if message.Seq > 0 {
+		return fmt.Sprintf("%s-%d", epoch, message.Seq)
+	}
+	if message.Type == "system" && message.Action == "hello" {
+		var hello websocket.Hello
+		if err := json.Unmarshal(message.Data, &hello); err == nil && !hello.Resumed {
+			return fmt.Sprintf("%s-%d", epoch, hello.Seq)
+		}
+	}
+	return ""
+}
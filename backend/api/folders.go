@@ -0,0 +1,325 @@
+package handlers
+
+import (
+	"strings"
+	"time"
+
+	"github.com/MiXiaoAi/oinote/backend/internal/collab"
+	"github.com/MiXiaoAi/oinote/backend/internal/links"
+	"github.com/MiXiaoAi/oinote/backend/internal/models"
+	"github.com/MiXiaoAi/oinote/backend/internal/websocket"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+type FolderHandler struct {
+	DB     *gorm.DB
+	Hub    *websocket.Hub
+	Collab *collab.YjsServer
+}
+
+func NewFolderHandler(db *gorm.DB, hub *websocket.Hub, yjsServer *collab.YjsServer) *FolderHandler {
+	return &FolderHandler{DB: db, Hub: hub, Collab: yjsServer}
+}
+
+// folderAudience 个人文件夹的事件只发给所有者，频道文件夹发给频道成员
+func folderAudience(folder *models.Folder) websocket.Audience {
+	if folder.ChannelID != nil {
+		return websocket.ToChannel(*folder.ChannelID)
+	}
+	return websocket.ToUsers(folder.OwnerID)
+}
+
+// canAccessFolderScope 个人文件夹只有所有者可以访问，频道文件夹需要是频道成员
+func canAccessFolderScope(db *gorm.DB, channelID *uint, ownerID uint, userId uint) bool {
+	if channelID == nil {
+		return ownerID == userId
+	}
+	return models.IsChannelMember(db, *channelID, userId)
+}
+
+// sameFolderScope 判断文件夹是否属于指定的个人或频道范围
+func sameFolderScope(folder *models.Folder, channelID *uint, ownerID uint) bool {
+	if channelID == nil {
+		return folder.ChannelID == nil && folder.OwnerID == ownerID
+	}
+	return folder.ChannelID != nil && *folder.ChannelID == *channelID
+}
+
+// validateNoteFolder 检查笔记可以放入该文件夹：文件夹存在且与笔记属于同一个人或频道
+func validateNoteFolder(db *gorm.DB, folderID uint, channelID *uint, ownerID uint) bool {
+	var folder models.Folder
+	if err := db.First(&folder, folderID).Error; err != nil {
+		return false
+	}
+	return sameFolderScope(&folder, channelID, ownerID)
+}
+
+// folderSubtree 返回文件夹及其所有子文件夹的ID，unscoped 为 true 时包括回收站中的文件夹
+func folderSubtree(db *gorm.DB, rootID uint, unscoped bool) []uint {
+	ids := []uint{rootID}
+	for frontier := []uint{rootID}; len(frontier) > 0; {
+		query := db
+		if unscoped {
+			query = db.Unscoped()
+		}
+		var children []uint
+		query.Model(&models.Folder{}).Where("parent_id IN ?", frontier).Pluck("id", &children)
+		ids = append(ids, children...)
+		frontier = children
+	}
+	return ids
+}
+
+// isFolderAncestor 判断 ancestorID 是否是 folderID 本身或其上级文件夹，用于避免移动后形成循环
+func isFolderAncestor(db *gorm.DB, ancestorID, folderID uint) bool {
+	for id := &folderID; id != nil; {
+		if *id == ancestorID {
+			return true
+		}
+		var folder models.Folder
+		if err := db.Select("parent_id").First(&folder, *id).Error; err != nil {
+			return false
+		}
+		id = folder.ParentID
+	}
+	return false
+}
+
+// reorderFolder 把文件夹放到同级文件夹的指定位置，并重新编号同级文件夹
+func reorderFolder(tx *gorm.DB, folder *models.Folder, position int) error {
+	query := tx.Model(&models.Folder{}).Where("id <> ?", folder.ID)
+	if folder.ChannelID != nil {
+		query = query.Where("channel_id = ?", *folder.ChannelID)
+	} else {
+		query = query.Where("channel_id IS NULL AND owner_id = ?", folder.OwnerID)
+	}
+	if folder.ParentID != nil {
+		query = query.Where("parent_id = ?", *folder.ParentID)
+	} else {
+		query = query.Where("parent_id IS NULL")
+	}
+
+	var siblings []uint
+	query.Order("position, id").Pluck("id", &siblings)
+
+	if position < 0 || position > len(siblings) {
+		position = len(siblings)
+	}
+	ordered := append(append(append([]uint{}, siblings[:position]...), folder.ID), siblings[position:]...)
+	for index, id := range ordered {
+		if err := tx.Model(&models.Folder{}).Where("id = ?", id).UpdateColumn("position", index).Error; err != nil {
+			return err
+		}
+	}
+	folder.Position = position
+	return nil
+}
+
+// GetFolders 获取个人或频道的全部文件夹，按层级和顺序排列，客户端根据 parent_id 组成树
+func (h *FolderHandler) GetFolders(c *fiber.Ctx) error {
+	userId := c.Locals("userId").(uint)
+
+	query := h.DB.Model(&models.Folder{})
+	if channelId := c.QueryInt("channel_id", 0); channelId > 0 {
+		if !models.IsChannelMember(h.DB, uint(channelId), userId) {
+			return c.Status(403).JSON(fiber.Map{"error": "无权访问该频道"})
+		}
+		query = query.Where("channel_id = ?", channelId)
+	} else {
+		query = query.Where("channel_id IS NULL AND owner_id = ?", userId)
+	}
+
+	folders := []models.Folder{}
+	query.Order("position, id").Find(&folders)
+	return c.JSON(folders)
+}
+
+// CreateFolder 创建文件夹，parent_id 为空时创建在顶层，新文件夹排在同级最后
+func (h *FolderHandler) CreateFolder(c *fiber.Ctx) error {
+	userId := c.Locals("userId").(uint)
+
+	var input struct {
+		Name      string `json:"name"`
+		ParentID  *uint  `json:"parent_id"`
+		ChannelID *uint  `json:"channel_id"`
+	}
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "输入数据无效"})
+	}
+
+	input.Name = strings.TrimSpace(input.Name)
+	if input.Name == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "文件夹名称不能为空"})
+	}
+	if !canAccessFolderScope(h.DB, input.ChannelID, userId, userId) {
+		return c.Status(403).JSON(fiber.Map{"error": "无权访问该频道"})
+	}
+
+	folder := models.Folder{
+		Name:      input.Name,
+		ParentID:  input.ParentID,
+		ChannelID: input.ChannelID,
+		OwnerID:   userId,
+	}
+	if folder.ParentID != nil && !validateNoteFolder(h.DB, *folder.ParentID, folder.ChannelID, userId) {
+		return c.Status(400).JSON(fiber.Map{"error": "上级文件夹不存在"})
+	}
+
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&folder).Error; err != nil {
+			return err
+		}
+		return reorderFolder(tx, &folder, -1)
+	})
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "创建文件夹失败"})
+	}
+
+	h.Hub.Publish(folderAudience(&folder), "folder", "create", folder)
+
+	return c.JSON(folder)
+}
+
+// loadEditableFolder 加载当前用户可以管理的文件夹
+func (h *FolderHandler) loadEditableFolder(c *fiber.Ctx) (*models.Folder, error) {
+	userId := c.Locals("userId").(uint)
+
+	var folder models.Folder
+	if err := h.DB.First(&folder, c.Params("id")).Error; err != nil {
+		return nil, c.Status(404).JSON(fiber.Map{"error": "文件夹不存在"})
+	}
+	if !canAccessFolderScope(h.DB, folder.ChannelID, folder.OwnerID, userId) {
+		return nil, c.Status(404).JSON(fiber.Map{"error": "文件夹不存在"})
+	}
+	return &folder, nil
+}
+
+// UpdateFolder 重命名文件夹
+func (h *FolderHandler) UpdateFolder(c *fiber.Ctx) error {
+	folder, err := h.loadEditableFolder(c)
+	if folder == nil {
+		return err
+	}
+
+	var input struct {
+		Name string `json:"name"`
+	}
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "输入数据无效"})
+	}
+
+	input.Name = strings.TrimSpace(input.Name)
+	if input.Name == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "文件夹名称不能为空"})
+	}
+
+	if err := h.DB.Model(folder).Update("name", input.Name).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "更新文件夹失败"})
+	}
+
+	h.Hub.Publish(folderAudience(folder), "folder", "update", folder)
+
+	return c.JSON(folder)
+}
+
+// MoveFolder 移动文件夹到另一个上级文件夹（parent_id 为空表示顶层）并调整顺序
+// position 为在新的同级文件夹中的位置，省略时排在最后
+func (h *FolderHandler) MoveFolder(c *fiber.Ctx) error {
+	folder, err := h.loadEditableFolder(c)
+	if folder == nil {
+		return err
+	}
+
+	var input struct {
+		ParentID *uint `json:"parent_id"`
+		Position *int  `json:"position"`
+	}
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "输入数据无效"})
+	}
+
+	if input.ParentID != nil {
+		if !validateNoteFolder(h.DB, *input.ParentID, folder.ChannelID, folder.OwnerID) {
+			return c.Status(400).JSON(fiber.Map{"error": "上级文件夹不存在"})
+		}
+		if isFolderAncestor(h.DB, folder.ID, *input.ParentID) {
+			return c.Status(400).JSON(fiber.Map{"error": "不能移动到自身或其子文件夹中"})
+		}
+	}
+
+	position := -1
+	if input.Position != nil {
+		position = *input.Position
+	}
+
+	err = h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(folder).Update("parent_id", input.ParentID).Error; err != nil {
+			return err
+		}
+		folder.ParentID = input.ParentID
+		return reorderFolder(tx, folder, position)
+	})
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "移动文件夹失败"})
+	}
+
+	h.Hub.Publish(folderAudience(folder), "folder", "update", folder)
+
+	return c.JSON(folder)
+}
+
+// DeleteFolder 删除文件夹，子文件夹和其中的笔记一并移入回收站，使用相同的删除时间以便整体恢复
+// 个人文件夹只有所有者可以删除，频道文件夹由创建者或频道管理员删除
+func (h *FolderHandler) DeleteFolder(c *fiber.Ctx) error {
+	userId := c.Locals("userId").(uint)
+	folder, err := h.loadEditableFolder(c)
+	if folder == nil {
+		return err
+	}
+
+	if folder.ChannelID != nil && folder.OwnerID != userId {
+		var membership models.ChannelMember
+		if err := h.DB.Where("channel_id = ? AND user_id = ? AND status = ? AND (role = ? OR role = ?)",
+			*folder.ChannelID, userId, models.MemberStatusActive, models.RoleOwner, models.RoleAdmin).
+			First(&membership).Error; err != nil {
+			return c.Status(403).JSON(fiber.Map{"error": "无权删除该文件夹"})
+		}
+	}
+
+	folderIDs := folderSubtree(h.DB, folder.ID, false)
+
+	// 先写回其中笔记的协同内容
+	var notes []models.Note
+	h.DB.Where("folder_id IN ?", folderIDs).Find(&notes)
+	noteIDs := make([]uint, 0, len(notes))
+	for _, note := range notes {
+		h.Collab.CloseDocument(note.ID)
+		noteIDs = append(noteIDs, note.ID)
+	}
+
+	deletedAt := time.Now()
+	err = h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.Note{}).Where("folder_id IN ?", folderIDs).Update("deleted_at", deletedAt).Error; err != nil {
+			return err
+		}
+		return tx.Model(&models.Folder{}).Where("id IN ?", folderIDs).Update("deleted_at", deletedAt).Error
+	})
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "删除文件夹失败"})
+	}
+
+	for i := range notes {
+		links.Relink(h.DB, &notes[i], notes[i].Title)
+	}
+
+	h.Hub.Publish(folderAudience(folder), "folder", "delete", fiber.Map{
+		"id":         folder.ID,
+		"folder_ids": folderIDs,
+	})
+	for _, noteID := range noteIDs {
+		h.Hub.Publish(websocket.ToNote(noteID), "note", "delete", fiber.Map{"id": noteID})
+	}
+
+	return c.SendStatus(204)
+}
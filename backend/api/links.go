@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"time"
+
+	"github.com/MiXiaoAi/oinote/backend/internal/models"
+	"github.com/gofiber/fiber/v2"
+)
+
+// GraphNode 笔记关系图中的笔记
+type GraphNode struct {
+	ID        uint      `json:"id"`
+	Title     string    `json:"title"`
+	FolderID  *uint     `json:"folder_id"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// GraphEdge 笔记关系图中从 Source 引用 Target 的边
+type GraphEdge struct {
+	Source uint `json:"source"`
+	Target uint `json:"target"`
+}
+
+// GetNoteBacklinks 获取引用了该笔记的笔记，只返回当前用户可以查看的笔记
+func (h *NoteHandler) GetNoteBacklinks(c *fiber.Ctx) error {
+	userId := currentUserID(c)
+
+	var note models.Note
+	if err := h.DB.First(&note, "id = ?", c.Params("id")).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "笔记不存在"})
+	}
+	if !models.CanViewNote(h.DB, &note, userId) {
+		return c.Status(403).JSON(fiber.Map{"error": "无权访问该笔记"})
+	}
+
+	var sources []models.Note
+	h.DB.Preload("Owner").
+		Where("id IN (?)", h.DB.Model(&models.NoteLink{}).Select("source_id").Where("target_id = ?", note.ID)).
+		Order("updated_at DESC").Find(&sources)
+
+	backlinks := make([]models.Note, 0, len(sources))
+	for _, source := range sources {
+		if source.ID != note.ID && models.CanViewNote(h.DB, &source, userId) {
+			backlinks = append(backlinks, source)
+		}
+	}
+
+	return c.JSON(backlinks)
+}
+
+// GetNoteGraph 获取个人笔记或频道笔记（channel_id）之间的引用关系图
+func (h *NoteHandler) GetNoteGraph(c *fiber.Ctx) error {
+	userId := c.Locals("userId").(uint)
+
+	query := h.DB.Model(&models.Note{})
+	if channelId := c.QueryInt("channel_id", 0); channelId > 0 {
+		if !models.IsChannelMember(h.DB, uint(channelId), userId) {
+			return c.Status(403).JSON(fiber.Map{"error": "无权访问该频道"})
+		}
+		query = query.Where("channel_id = ?", channelId)
+	} else {
+		query = query.Where("channel_id IS NULL AND owner_id = ?", userId)
+	}
+
+	nodes := []GraphNode{}
+	query.Select("id, title, folder_id, updated_at").Order("id").Scan(&nodes)
+
+	inGraph := make(map[uint]bool, len(nodes))
+	ids := make([]uint, 0, len(nodes))
+	for _, node := range nodes {
+		inGraph[node.ID] = true
+		ids = append(ids, node.ID)
+	}
+
+	var noteLinks []models.NoteLink
+	if len(ids) > 0 {
+		h.DB.Where("source_id IN ? AND target_id IS NOT NULL", ids).Order("source_id, target_id").Find(&noteLinks)
+	}
+
+	edges := []GraphEdge{}
+	for _, link := range noteLinks {
+		if link.SourceID != *link.TargetID && inGraph[*link.TargetID] {
+			edges = append(edges, GraphEdge{Source: link.SourceID, Target: *link.TargetID})
+		}
+	}
+
+	return c.JSON(fiber.Map{
+		"nodes": nodes,
+		"edges": edges,
+	})
+}
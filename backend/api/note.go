@@ -3,6 +3,7 @@ package handlers
 import (
 	"github.com/MiXiaoAi/oinote/backend/config"
 	"github.com/MiXiaoAi/oinote/backend/internal/models"
+	"github.com/MiXiaoAi/oinote/backend/internal/search"
 	"github.com/gofiber/fiber/v2"
 )
 
@@ -18,16 +19,14 @@ func CreateNote(c *fiber.Ctx) error {
 
 	// 如果是频道笔记，检查成员权限
 	if input.ChannelID != nil {
-		var member models.ChannelMember
-		if err := config.DB.Where("channel_id = ? AND user_id = ? AND status = ?",
-			*input.ChannelID, userID, models.MemberStatusActive).First(&member).Error; err != nil {
+		if !models.IsChannelMember(config.DB, *input.ChannelID, userID) {
 			return c.Status(403).JSON(fiber.Map{"error": "你不是该频道成员"})
 		}
 	}
 
-	// 查找第一个可用的空ID（填充ID间隙）
+	// 查找第一个可用的空ID（填充ID间隙），回收站中的记录仍占用ID
 	var existingIDs []uint
-	config.DB.Model(&models.Note{}).Order("id").Pluck("id", &existingIDs)
+	config.DB.Unscoped().Model(&models.Note{}).Order("id").Pluck("id", &existingIDs)
 
 	nextAvailableID := uint(1)
 	for _, id := range existingIDs {
@@ -50,6 +49,8 @@ func CreateNote(c *fiber.Ctx) error {
 		return c.Status(500).JSON(fiber.Map{"error": "创建笔记失败: " + result.Error.Error()})
 	}
 
+	search.IndexNote(config.DB, &input)
+
 	return c.JSON(input)
 }
 
@@ -7,19 +7,24 @@ import (
 	"strings"
 	"time"
 
+	"github.com/MiXiaoAi/oinote/backend/internal/collab"
+	"github.com/MiXiaoAi/oinote/backend/internal/links"
 	"github.com/MiXiaoAi/oinote/backend/internal/models"
+	"github.com/MiXiaoAi/oinote/backend/internal/notify"
+	"github.com/MiXiaoAi/oinote/backend/internal/search"
 	"github.com/MiXiaoAi/oinote/backend/internal/websocket"
 	"github.com/gofiber/fiber/v2"
 	"gorm.io/gorm"
 )
 
 type NoteHandler struct {
-	DB  *gorm.DB
-	Hub *websocket.Hub
+	DB     *gorm.DB
+	Hub    *websocket.Hub
+	Collab *collab.YjsServer
 }
 
-func NewNoteHandler(db *gorm.DB, hub *websocket.Hub) *NoteHandler {
-	return &NoteHandler{DB: db, Hub: hub}
+func NewNoteHandler(db *gorm.DB, hub *websocket.Hub, yjsServer *collab.YjsServer) *NoteHandler {
+	return &NoteHandler{DB: db, Hub: hub, Collab: yjsServer}
 }
 
 func (h *NoteHandler) CreateNote(c *fiber.Ctx) error {
@@ -31,9 +36,13 @@ func (h *NoteHandler) CreateNote(c *fiber.Ctx) error {
 
 	note.OwnerID = userId
 
-	// 查找第一个可用的空ID（填充ID间隙）
+	if note.FolderID != nil && !validateNoteFolder(h.DB, *note.FolderID, note.ChannelID, userId) {
+		return c.Status(400).JSON(fiber.Map{"error": "文件夹不存在"})
+	}
+
+	// 查找第一个可用的空ID（填充ID间隙），回收站中的记录仍占用ID
 	var existingIDs []uint
-	h.DB.Model(&models.Note{}).Order("id").Pluck("id", &existingIDs)
+	h.DB.Unscoped().Model(&models.Note{}).Order("id").Pluck("id", &existingIDs)
 
 	nextAvailableID := uint(1)
 	for _, id := range existingIDs {
@@ -49,8 +58,8 @@ func (h *NoteHandler) CreateNote(c *fiber.Ctx) error {
 	note.ID = nextAvailableID
 
 	// 使用Raw SQL插入，确保使用指定的ID
-	result := h.DB.Exec("INSERT INTO notes (id, created_at, updated_at, title, content, channel_id, owner_id, is_public, tags) VALUES (?, datetime('now'), datetime('now'), ?, ?, ?, ?, ?, ?)",
-		note.ID, note.Title, note.Content, note.ChannelID, note.OwnerID, note.IsPublic, note.Tags)
+	result := h.DB.Exec("INSERT INTO notes (id, created_at, updated_at, title, content, channel_id, folder_id, owner_id, is_public, tags) VALUES (?, datetime('now'), datetime('now'), ?, ?, ?, ?, ?, ?, ?)",
+		note.ID, note.Title, note.Content, note.ChannelID, note.FolderID, note.OwnerID, note.IsPublic, note.Tags)
 
 	if result.Error != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "创建笔记失败: " + result.Error.Error()})
@@ -59,8 +68,16 @@ func (h *NoteHandler) CreateNote(c *fiber.Ctx) error {
 	// 重新加载笔记信息，包括所有者
 	h.DB.Preload("Owner").First(&note, note.ID)
 
+	// 记录初始版本
+	collab.RecordRevision(h.DB, &note, userId, models.RevisionSourceSave, nil)
+	search.IndexNote(h.DB, &note)
+	notify.NoteMentions(h.DB, h.Hub, &note, "", userId)
+	links.Update(h.DB, &note)
+	// 新笔记可能是已有引用中写到的标题
+	links.Relink(h.DB, &note, note.Title)
+
 	// 广播笔记创建消息
-	h.Hub.BroadcastMessage("note", "create", note)
+	h.Hub.Publish(websocket.ToNote(note.ID), "note", "create", note)
 
 	return c.JSON(note)
 }
@@ -70,17 +87,32 @@ func (h *NoteHandler) GetNotes(c *fiber.Ctx) error {
 	userId := c.Locals("userId")
 	hasUserId := userId != nil
 
+	// folder_id 筛选文件夹中的笔记，为 0 时只返回不在文件夹中的笔记，省略时不筛选
+	inFolder := func(db *gorm.DB) *gorm.DB { return db }
+	if folder := c.Query("folder_id"); folder != "" {
+		folderId := c.QueryInt("folder_id", -1)
+		if folderId < 0 {
+			return c.Status(400).JSON(fiber.Map{"error": "无效的文件夹ID"})
+		}
+		inFolder = func(db *gorm.DB) *gorm.DB {
+			if folderId == 0 {
+				return db.Where("folder_id IS NULL")
+			}
+			return db.Where("folder_id = ?", folderId)
+		}
+	}
+
 	var notes []models.Note
 
 	if channelId != 0 {
 		// 获取频道下的公开笔记
-		query := h.DB.Where("channel_id = ? AND is_public = ?", channelId, true)
+		query := h.DB.Scopes(inFolder).Where("channel_id = ? AND is_public = ?", channelId, true)
 		query.Preload("Owner").Find(&notes)
 
 		// 如果用户已登录，还返回用户自己的笔记（无论是否公开）
 		if hasUserId {
 			var userNotes []models.Note
-			h.DB.Where("channel_id = ? AND owner_id = ?", channelId, userId.(uint)).Preload("Owner").Find(&userNotes)
+			h.DB.Scopes(inFolder).Where("channel_id = ? AND owner_id = ?", channelId, userId.(uint)).Preload("Owner").Find(&userNotes)
 
 			// 合并并去重
 			noteMap := make(map[uint]models.Note)
@@ -99,10 +131,10 @@ func (h *NoteHandler) GetNotes(c *fiber.Ctx) error {
 	} else {
 		// 未认证用户只能看公开笔记
 		if !hasUserId {
-			h.DB.Where("is_public = ?", true).Preload("Owner").Find(&notes)
+			h.DB.Scopes(inFolder).Where("is_public = ?", true).Preload("Owner").Find(&notes)
 		} else {
 			// 认证用户看自己的所有笔记
-			query := h.DB.Where("owner_id = ?", userId.(uint))
+			query := h.DB.Scopes(inFolder).Where("owner_id = ?", userId.(uint))
 			query.Where("channel_id IS NULL")
 			query.Preload("Owner").Find(&notes)
 		}
@@ -117,30 +149,36 @@ func (h *NoteHandler) GetPublicNotes(c *fiber.Ctx) error {
 	return c.JSON(notes)
 }
 
+// SearchNotes 全文搜索笔记，返回带高亮摘要的笔记列表
 func (h *NoteHandler) SearchNotes(c *fiber.Ctx) error {
-	queryStr := c.Query("q")
-	if queryStr == "" {
-		return c.JSON([]models.Note{})
+	q, err := parseSearchQuery(c, h.DB)
+	if q == nil {
+		return err
 	}
+	q.Type = search.KindNote
 
-	var notes []models.Note
-	
-	// 获取用户ID（可能为nil，因为使用了OptionalAuth）
-	userIdInterface := c.Locals("userId")
-	
-	if userIdInterface != nil {
-		// 用户已登录：搜索用户自己的笔记或公开的笔记
-		userId := userIdInterface.(uint)
-		h.DB.Where("(owner_id = ? OR is_public = ?) AND (title LIKE ? OR content LIKE ? OR tags LIKE ?)", 
-			userId, true, "%"+queryStr+"%", "%"+queryStr+"%", "%"+queryStr+"%").
-			Preload("Owner").Find(&notes)
-	} else {
-		// 用户未登录：只搜索公开的笔记
-		h.DB.Where("is_public = ? AND (title LIKE ? OR content LIKE ? OR tags LIKE ?)", 
-			true, "%"+queryStr+"%", "%"+queryStr+"%", "%"+queryStr+"%").
-			Preload("Owner").Find(&notes)
+	results, _, err := search.Search(h.DB, *q)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "搜索失败"})
+	}
+
+	type NoteSearchResult struct {
+		models.Note
+		TitleHighlight string  `json:"title_highlight"`
+		Snippet        string  `json:"snippet"`
+		Rank           float64 `json:"rank"`
+	}
+
+	notes := make([]NoteSearchResult, 0, len(results))
+	for _, result := range results {
+		notes = append(notes, NoteSearchResult{
+			Note:           *result.Note,
+			TitleHighlight: result.TitleHighlight,
+			Snippet:        result.Snippet,
+			Rank:           result.Rank,
+		})
 	}
-	
+
 	return c.JSON(notes)
 }
 
@@ -153,21 +191,7 @@ func (h *NoteHandler) UpdateNote(c *fiber.Ctx) error {
 		return c.Status(404).JSON(fiber.Map{"error": "笔记不存在"})
 	}
 
-	// 权限检查：个人笔记只能作者编辑，频道笔记所有成员都能编辑
-	canEdit := false
-	if note.ChannelID != nil {
-		// 频道笔记：检查是否是频道成员
-		var membership models.ChannelMember
-		if err := h.DB.Where("channel_id = ? AND user_id = ? AND status = ?", 
-			*note.ChannelID, userId, models.MemberStatusActive).First(&membership).Error; err == nil {
-			canEdit = true
-		}
-	} else {
-		// 个人笔记：只有作者可以编辑
-		canEdit = (note.OwnerID == userId)
-	}
-
-	if !canEdit {
+	if !h.canEditNote(&note, userId) {
 		return c.Status(403).JSON(fiber.Map{"error": "笔记不存在或无权修改"})
 	}
 
@@ -184,6 +208,13 @@ func (h *NoteHandler) UpdateNote(c *fiber.Ctx) error {
 		return c.Status(400).JSON(fiber.Map{"error": "输入数据无效"})
 	}
 
+	// 共享的编辑者只能修改笔记内容，不能修改公开状态
+	if input.IsPublic != nil && *input.IsPublic != note.IsPublic && !h.canManageNote(&note, userId) {
+		return c.Status(403).JSON(fiber.Map{"error": "无权修改笔记的公开状态"})
+	}
+
+	oldContent := note.Content
+	oldTitle := note.Title
 	if input.Title != nil {
 		note.Title = *input.Title
 	}
@@ -204,6 +235,20 @@ func (h *NoteHandler) UpdateNote(c *fiber.Ctx) error {
 		return c.Status(500).JSON(fiber.Map{"error": "更新笔记失败"})
 	}
 
+	// 标题或内容变化时记录历史版本，编辑器自动保存频繁，按间隔保留
+	if input.Title != nil || input.Content != nil {
+		collab.RecordThrottledRevision(h.DB, &note, userId, models.RevisionSourceSave)
+	}
+	search.IndexNote(h.DB, &note)
+	notify.NoteMentions(h.DB, h.Hub, &note, oldContent, userId)
+	if input.Content != nil {
+		links.Update(h.DB, &note)
+	}
+	// 改名后引用旧标题的笔记不再指向它，引用新标题的笔记指向它
+	if note.Title != oldTitle {
+		links.Relink(h.DB, &note, oldTitle, note.Title)
+	}
+
 	// 清理不再使用的附件
 	if input.Content != nil {
 		// 获取该笔记的所有附件
@@ -284,11 +329,60 @@ func (h *NoteHandler) UpdateNote(c *fiber.Ctx) error {
 	h.DB.Preload("Owner").First(&note, note.ID)
 
 	// 广播笔记更新消息
-	h.Hub.BroadcastMessage("note", "update", note)
+	h.Hub.Publish(websocket.ToNote(note.ID), "note", "update", note)
 
 	return c.JSON(note)
 }
 
+// MoveNote 把笔记移动到同一个人或频道范围内的文件夹，folder_id 为空表示移出文件夹
+func (h *NoteHandler) MoveNote(c *fiber.Ctx) error {
+	userId := c.Locals("userId").(uint)
+
+	var note models.Note
+	if err := h.DB.First(&note, c.Params("id")).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "笔记不存在"})
+	}
+
+	if !h.canManageNote(&note, userId) {
+		return c.Status(403).JSON(fiber.Map{"error": "笔记不存在或无权修改"})
+	}
+
+	var input struct {
+		FolderID *uint `json:"folder_id"`
+	}
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "输入数据无效"})
+	}
+
+	if input.FolderID != nil && !validateNoteFolder(h.DB, *input.FolderID, note.ChannelID, note.OwnerID) {
+		return c.Status(400).JSON(fiber.Map{"error": "文件夹不存在"})
+	}
+
+	if err := h.DB.Model(&note).Update("folder_id", input.FolderID).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "移动笔记失败"})
+	}
+
+	h.DB.Preload("Owner").First(&note, note.ID)
+
+	h.Hub.Publish(websocket.ToNote(note.ID), "note", "update", note)
+
+	return c.JSON(note)
+}
+
+// canEditNote 权限检查：个人笔记只能作者编辑，频道笔记所有成员都能编辑，共享为编辑者的用户也能编辑
+func (h *NoteHandler) canEditNote(note *models.Note, userId uint) bool {
+	return h.canManageNote(note, userId) || models.NoteShareRole(h.DB, note.ID, userId) == models.ShareRoleEditor
+}
+
+// canManageNote 个人笔记的作者或频道笔记所属频道的成员，可以修改公开状态、移动笔记和管理共享
+func (h *NoteHandler) canManageNote(note *models.Note, userId uint) bool {
+	if note.ChannelID == nil {
+		return note.OwnerID == userId
+	}
+
+	return models.IsChannelMember(h.DB, *note.ChannelID, userId)
+}
+
 func (h *NoteHandler) GetNote(c *fiber.Ctx) error {
 	userId := c.Locals("userId")
 	noteId := c.Params("id")
@@ -298,16 +392,19 @@ func (h *NoteHandler) GetNote(c *fiber.Ctx) error {
 		return c.Status(404).JSON(fiber.Map{"error": "笔记不存在"})
 	}
 
-	// 公开笔记允许访客访问，否则只允许所有者访问
+	// 公开笔记允许访客访问，否则只允许所有者、频道成员和被共享的用户访问
 	if !note.IsPublic {
 		if userId == nil {
 			return c.Status(403).JSON(fiber.Map{"error": "无权访问该笔记"})
 		}
-		if note.OwnerID != userId.(uint) {
+		if !models.CanViewNote(h.DB, &note, userId.(uint)) {
 			return c.Status(403).JSON(fiber.Map{"error": "无权访问该笔记"})
 		}
 	}
 
+	note.Reactions = loadReactions(h.DB, models.ReactionTargetNote, []uint{note.ID}, currentUserID(c))[note.ID]
+	note.ShareRole = models.NoteShareRole(h.DB, note.ID, currentUserID(c))
+
 	return c.JSON(note)
 }
 
@@ -321,31 +418,19 @@ func (h *NoteHandler) DeleteNote(c *fiber.Ctx) error {
 		return c.Status(404).JSON(fiber.Map{"error": "笔记不存在或无权删除"})
 	}
 
-	// 获取该笔记的所有附件
-	var attachments []models.Attachment
-	h.DB.Where("note_id = ?", note.ID).Find(&attachments)
-
-	// 删除所有附件文件
-	for _, attachment := range attachments {
-		if attachment.FilePath != "" {
-			fullPath := filepath.Join("./data", attachment.FilePath)
-			os.Remove(fullPath)
-		}
-	}
-
-	// 删除附件记录
-	h.DB.Where("note_id = ?", note.ID).Delete(&models.Attachment{})
-
-	// 删除笔记目录
-	noteDir := filepath.Join("./data/uploads/notes", "note_"+noteId)
-	os.RemoveAll(noteDir)
+	// 先写回协同内容，附件、协同文档和历史版本保留到回收站清理时再删除
+	h.Collab.CloseDocument(note.ID)
 
+	// 软删除，笔记移入回收站
 	if err := h.DB.Delete(&note).Error; err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "删除笔记失败"})
 	}
 
+	// 引用该笔记的记录改为指向其他同名笔记或断开
+	links.Relink(h.DB, &note, note.Title)
+
 	// 广播笔记删除消息
-	h.Hub.BroadcastMessage("note", "delete", fiber.Map{
+	h.Hub.Publish(websocket.ToNote(note.ID), "note", "delete", fiber.Map{
 		"id": noteId,
 	})
 
@@ -370,7 +455,7 @@ func (h *NoteHandler) GetAllNotes(c *fiber.Ctx) error {
 	return c.JSON(notes)
 }
 
-// AdminDeleteNote 管理员删除笔记
+// AdminDeleteNote 管理员删除笔记（移入回收站）
 func (h *NoteHandler) AdminDeleteNote(c *fiber.Ctx) error {
 	noteId := c.Params("id")
 
@@ -379,28 +464,15 @@ func (h *NoteHandler) AdminDeleteNote(c *fiber.Ctx) error {
 		return c.Status(404).JSON(fiber.Map{"error": "笔记不存在"})
 	}
 
-	// 获取该笔记的所有附件
-	var attachments []models.Attachment
-	h.DB.Where("note_id = ?", note.ID).Find(&attachments)
-
-	// 删除所有附件文件
-	for _, attachment := range attachments {
-		if attachment.FilePath != "" {
-			fullPath := filepath.Join("./data", attachment.FilePath)
-			os.Remove(fullPath)
-		}
-	}
-
-	// 删除附件记录
-	h.DB.Where("note_id = ?", note.ID).Delete(&models.Attachment{})
-
-	// 删除笔记目录
-	noteDir := filepath.Join("./data/uploads/notes", "note_"+noteId)
-	os.RemoveAll(noteDir)
+	// 先写回协同内容，附件、协同文档和历史版本保留到回收站清理时再删除
+	h.Collab.CloseDocument(note.ID)
 
+	// 软删除，笔记移入回收站
 	if err := h.DB.Delete(&note).Error; err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "删除笔记失败"})
 	}
 
+	links.Relink(h.DB, &note, note.Title)
+
 	return c.JSON(fiber.Map{"message": "删除成功"})
 }
@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"time"
+
+	"github.com/MiXiaoAi/oinote/backend/internal/models"
+	"github.com/MiXiaoAi/oinote/backend/internal/websocket"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// 通知分页
+const (
+	defaultNotificationLimit = 20
+	maxNotificationLimit     = 100
+)
+
+type NotificationHandler struct {
+	DB  *gorm.DB
+	Hub *websocket.Hub
+}
+
+func NewNotificationHandler(db *gorm.DB, hub *websocket.Hub) *NotificationHandler {
+	return &NotificationHandler{DB: db, Hub: hub}
+}
+
+// NotificationReadEvent 通知已读或删除事件，用于同步用户的其他设备
+type NotificationReadEvent struct {
+	IDs         []uint `json:"ids"`
+	UnreadCount int64  `json:"unread_count"`
+}
+
+// visibleNotifications 用户的通知，关联的消息或笔记在回收站中时不显示
+func (h *NotificationHandler) visibleNotifications(userId uint) *gorm.DB {
+	return h.DB.Model(&models.Notification{}).
+		Where("user_id = ?", userId).
+		Where("message_id IS NULL OR message_id IN (SELECT id FROM channel_messages WHERE deleted_at IS NULL)").
+		Where("note_id IS NULL OR note_id IN (SELECT id FROM notes WHERE deleted_at IS NULL)")
+}
+
+// unreadCount 用户的未读通知数
+func (h *NotificationHandler) unreadCount(userId uint) int64 {
+	var count int64
+	h.visibleNotifications(userId).Where("read_at IS NULL").Count(&count)
+	return count
+}
+
+// GetNotifications 获取通知列表，按时间倒序，支持 unread=true 只看未读和 before 游标分页
+func (h *NotificationHandler) GetNotifications(c *fiber.Ctx) error {
+	userId := c.Locals("userId").(uint)
+
+	limit := c.QueryInt("limit", defaultNotificationLimit)
+	if limit <= 0 || limit > maxNotificationLimit {
+		limit = defaultNotificationLimit
+	}
+
+	query := h.visibleNotifications(userId).Preload("Actor")
+	if c.QueryBool("unread") {
+		query = query.Where("read_at IS NULL")
+	}
+	if before := c.QueryInt("before", 0); before > 0 {
+		query = query.Where("id < ?", before)
+	}
+
+	var notifications []models.Notification
+	// 多取一条判断是否还有更早的通知
+	query.Order("id DESC").Limit(limit + 1).Find(&notifications)
+	hasMore := len(notifications) > limit
+	if hasMore {
+		notifications = notifications[:limit]
+	}
+
+	return c.JSON(fiber.Map{
+		"notifications": notifications,
+		"unread_count":  h.unreadCount(userId),
+		"has_more":      hasMore,
+	})
+}
+
+// MarkNotificationRead 把一条通知标记为已读
+func (h *NotificationHandler) MarkNotificationRead(c *fiber.Ctx) error {
+	userId := c.Locals("userId").(uint)
+
+	var notification models.Notification
+	if err := h.DB.Where("id = ? AND user_id = ?", c.Params("id"), userId).First(&notification).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "通知不存在"})
+	}
+
+	if notification.ReadAt == nil {
+		now := time.Now()
+		if err := h.DB.Model(&notification).Update("read_at", now).Error; err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "标记已读失败"})
+		}
+	}
+
+	event := NotificationReadEvent{IDs: []uint{notification.ID}, UnreadCount: h.unreadCount(userId)}
+	h.Hub.Publish(websocket.ToUsers(userId), "notification", "read", event)
+
+	return c.JSON(event)
+}
+
+// MarkAllNotificationsRead 把所有未读通知标记为已读
+func (h *NotificationHandler) MarkAllNotificationsRead(c *fiber.Ctx) error {
+	userId := c.Locals("userId").(uint)
+
+	var ids []uint
+	h.DB.Model(&models.Notification{}).Where("user_id = ? AND read_at IS NULL", userId).Pluck("id", &ids)
+	if len(ids) > 0 {
+		if err := h.DB.Model(&models.Notification{}).Where("id IN ?", ids).Update("read_at", time.Now()).Error; err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "标记已读失败"})
+		}
+	}
+
+	event := NotificationReadEvent{IDs: ids, UnreadCount: h.unreadCount(userId)}
+	if event.IDs == nil {
+		event.IDs = []uint{}
+	}
+	h.Hub.Publish(websocket.ToUsers(userId), "notification", "read", event)
+
+	return c.JSON(event)
+}
+
+// DeleteNotification 删除一条通知
+func (h *NotificationHandler) DeleteNotification(c *fiber.Ctx) error {
+	userId := c.Locals("userId").(uint)
+
+	var notification models.Notification
+	if err := h.DB.Where("id = ? AND user_id = ?", c.Params("id"), userId).First(&notification).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "通知不存在"})
+	}
+
+	if err := h.DB.Delete(&notification).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "删除通知失败"})
+	}
+
+	event := NotificationReadEvent{IDs: []uint{notification.ID}, UnreadCount: h.unreadCount(userId)}
+	h.Hub.Publish(websocket.ToUsers(userId), "notification", "delete", event)
+
+	return c.JSON(event)
+}
@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"github.com/MiXiaoAi/oinote/backend/internal/models"
+	"github.com/MiXiaoAi/oinote/backend/internal/websocket"
+	"github.com/gofiber/fiber/v2"
+)
+
+// MemberWithPresence 带在线状态的频道成员
+type MemberWithPresence struct {
+	models.ChannelMember
+	Presence websocket.PresenceInfo `json:"presence"`
+}
+
+// requireMembership 确认当前用户是频道的正式成员
+func (h *ChannelHandler) requireMembership(c *fiber.Ctx) (*models.ChannelMember, error) {
+	userId := c.Locals("userId").(uint)
+
+	var membership models.ChannelMember
+	if err := h.DB.Where("channel_id = ? AND user_id = ? AND status = ?", c.Params("id"), userId, models.MemberStatusActive).
+		First(&membership).Error; err != nil {
+		return nil, c.Status(403).JSON(fiber.Map{"error": "无权访问该频道"})
+	}
+	return &membership, nil
+}
+
+// GetChannelMembers 获取频道成员列表及每个成员的在线状态
+func (h *ChannelHandler) GetChannelMembers(c *fiber.Ctx) error {
+	membership, err := h.requireMembership(c)
+	if membership == nil {
+		return err
+	}
+
+	var members []models.ChannelMember
+	h.DB.Preload("User").Where("channel_id = ? AND status = ?", membership.ChannelID, models.MemberStatusActive).Find(&members)
+
+	userIDs := make([]uint, 0, len(members))
+	for _, member := range members {
+		userIDs = append(userIDs, member.UserID)
+	}
+	presence := h.Hub.Presence(userIDs)
+
+	result := make([]MemberWithPresence, 0, len(members))
+	for _, member := range members {
+		result = append(result, MemberWithPresence{ChannelMember: member, Presence: presence[member.UserID]})
+	}
+	return c.JSON(result)
+}
+
+// GetChannelPresence 获取频道中当前在线（含空闲）的成员和正在输入的成员
+func (h *ChannelHandler) GetChannelPresence(c *fiber.Ctx) error {
+	membership, err := h.requireMembership(c)
+	if membership == nil {
+		return err
+	}
+
+	here, typing := h.Hub.ChannelPresence(membership.ChannelID)
+	return c.JSON(fiber.Map{
+		"here":   here,
+		"typing": typing,
+	})
+}
+
+// SetTyping 报告当前用户在频道中开始或停止输入，供无法通过 WebSocket 上报的客户端使用
+func (h *ChannelHandler) SetTyping(c *fiber.Ctx) error {
+	membership, err := h.requireMembership(c)
+	if membership == nil {
+		return err
+	}
+
+	input := struct {
+		Typing *bool `json:"typing"`
+	}{}
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&input); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "输入数据无效"})
+		}
+	}
+	// 未指定时视为开始输入
+	typing := input.Typing == nil || *input.Typing
+
+	h.Hub.TouchUser(membership.UserID)
+	h.Hub.SetTyping(membership.ChannelID, membership.UserID, typing)
+	return c.JSON(fiber.Map{"typing": typing})
+}
@@ -0,0 +1,255 @@
+package handlers
+
+import (
+	"net/url"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/MiXiaoAi/oinote/backend/internal/models"
+	"github.com/MiXiaoAi/oinote/backend/internal/websocket"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 表情的最大长度（字符数），组合表情由多个字符组成
+const maxEmojiRunes = 16
+
+// ReactionEvent 表情回应变化事件
+type ReactionEvent struct {
+	TargetType string                   `json:"target_type"`
+	TargetID   uint                     `json:"target_id"`
+	ChannelID  *uint                    `json:"channel_id"`
+	UserID     uint                     `json:"user_id"`
+	Emoji      string                   `json:"emoji"`
+	Reactions  []models.ReactionSummary `json:"reactions"`
+}
+
+// validEmoji 校验表情：不能为空、不能包含空白且长度有限
+func validEmoji(emoji string) bool {
+	if emoji == "" || utf8.RuneCountInString(emoji) > maxEmojiRunes {
+		return false
+	}
+	return strings.IndexFunc(emoji, unicode.IsSpace) < 0
+}
+
+// loadReactions 统计一组对象的表情回应，按表情首次出现的顺序排列
+func loadReactions(db *gorm.DB, targetType string, targetIDs []uint, userID uint) map[uint][]models.ReactionSummary {
+	result := make(map[uint][]models.ReactionSummary)
+	if len(targetIDs) == 0 {
+		return result
+	}
+
+	var reactions []models.Reaction
+	db.Where("target_type = ? AND target_id IN ?", targetType, targetIDs).Order("id").Find(&reactions)
+
+	for _, reaction := range reactions {
+		summaries := result[reaction.TargetID]
+		index := -1
+		for i := range summaries {
+			if summaries[i].Emoji == reaction.Emoji {
+				index = i
+				break
+			}
+		}
+		if index < 0 {
+			summaries = append(summaries, models.ReactionSummary{Emoji: reaction.Emoji, UserIDs: []uint{}})
+			index = len(summaries) - 1
+		}
+		summaries[index].Count++
+		summaries[index].UserIDs = append(summaries[index].UserIDs, reaction.UserID)
+		if reaction.UserID == userID && userID != 0 {
+			summaries[index].Reacted = true
+		}
+		result[reaction.TargetID] = summaries
+	}
+	return result
+}
+
+// attachMessageReactions 为消息列表填充表情回应统计
+func attachMessageReactions(db *gorm.DB, messages []models.ChannelMessage, userID uint) {
+	ids := make([]uint, 0, len(messages))
+	for _, message := range messages {
+		ids = append(ids, message.ID)
+	}
+	reactions := loadReactions(db, models.ReactionTargetMessage, ids, userID)
+	for i := range messages {
+		messages[i].Reactions = reactions[messages[i].ID]
+	}
+}
+
+// currentUserID 返回当前登录用户ID，访客为 0
+func currentUserID(c *fiber.Ctx) uint {
+	if userId := c.Locals("userId"); userId != nil {
+		return userId.(uint)
+	}
+	return 0
+}
+
+// reactionInput 从请求体（添加）或路径（删除）中读取表情
+func reactionInput(c *fiber.Ctx) (string, bool) {
+	if emoji := c.Params("emoji"); emoji != "" {
+		decoded, err := url.PathUnescape(emoji)
+		if err != nil {
+			return "", false
+		}
+		return decoded, validEmoji(decoded)
+	}
+
+	var input struct {
+		Emoji string `json:"emoji"`
+	}
+	if err := c.BodyParser(&input); err != nil {
+		return "", false
+	}
+	input.Emoji = strings.TrimSpace(input.Emoji)
+	return input.Emoji, validEmoji(input.Emoji)
+}
+
+// toggleReaction 添加或删除表情回应，返回变化后的统计；重复添加或删除不存在的回应不报错
+func toggleReaction(db *gorm.DB, targetType string, targetID, userID uint, emoji string, add bool) ([]models.ReactionSummary, bool, error) {
+	var changed bool
+	if add {
+		result := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.Reaction{
+			TargetType: targetType,
+			TargetID:   targetID,
+			UserID:     userID,
+			Emoji:      emoji,
+		})
+		if result.Error != nil {
+			return nil, false, result.Error
+		}
+		changed = result.RowsAffected > 0
+	} else {
+		result := db.Where("target_type = ? AND target_id = ? AND user_id = ? AND emoji = ?", targetType, targetID, userID, emoji).
+			Delete(&models.Reaction{})
+		if result.Error != nil {
+			return nil, false, result.Error
+		}
+		changed = result.RowsAffected > 0
+	}
+
+	summaries := loadReactions(db, targetType, []uint{targetID}, userID)[targetID]
+	if summaries == nil {
+		summaries = []models.ReactionSummary{}
+	}
+	return summaries, changed, nil
+}
+
+// deleteReactions 删除对象的全部表情回应（对象被彻底删除时调用）
+func deleteReactions(db *gorm.DB, targetType string, targetIDs ...uint) {
+	if len(targetIDs) == 0 {
+		return
+	}
+	db.Where("target_type = ? AND target_id IN ?", targetType, targetIDs).Delete(&models.Reaction{})
+}
+
+// reactionEvent 生成广播的事件，统计中的 reacted 与接收者无关，因此去掉
+func reactionEvent(targetType string, targetID uint, channelID *uint, userID uint, emoji string, summaries []models.ReactionSummary) ReactionEvent {
+	shared := make([]models.ReactionSummary, len(summaries))
+	for i, summary := range summaries {
+		summary.Reacted = false
+		shared[i] = summary
+	}
+	return ReactionEvent{
+		TargetType: targetType,
+		TargetID:   targetID,
+		ChannelID:  channelID,
+		UserID:     userID,
+		Emoji:      emoji,
+		Reactions:  shared,
+	}
+}
+
+// reactToMessage 添加或删除频道消息的表情回应，需要是频道的正式成员
+func (h *ChannelHandler) reactToMessage(c *fiber.Ctx, add bool) error {
+	userId := c.Locals("userId").(uint)
+	channelId := c.Params("id")
+	messageId := c.Params("messageId")
+
+	if !models.IsChannelMember(h.DB, paramChannelID(c), userId) {
+		return c.Status(403).JSON(fiber.Map{"error": "无权访问该频道"})
+	}
+
+	var message models.ChannelMessage
+	if err := h.DB.Where("id = ? AND channel_id = ?", messageId, channelId).First(&message).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "消息不存在"})
+	}
+
+	emoji, ok := reactionInput(c)
+	if !ok {
+		return c.Status(400).JSON(fiber.Map{"error": "无效的表情"})
+	}
+
+	summaries, changed, err := toggleReaction(h.DB, models.ReactionTargetMessage, message.ID, userId, emoji, add)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "更新表情回应失败"})
+	}
+
+	if changed {
+		action := "remove"
+		if add {
+			action = "add"
+		}
+		h.Hub.Publish(websocket.ToChannel(message.ChannelID), "reaction", action,
+			reactionEvent(models.ReactionTargetMessage, message.ID, &message.ChannelID, userId, emoji, summaries))
+	}
+
+	return c.JSON(fiber.Map{"reactions": summaries})
+}
+
+// AddMessageReaction 为频道消息添加表情回应
+func (h *ChannelHandler) AddMessageReaction(c *fiber.Ctx) error {
+	return h.reactToMessage(c, true)
+}
+
+// RemoveMessageReaction 删除自己对频道消息的表情回应
+func (h *ChannelHandler) RemoveMessageReaction(c *fiber.Ctx) error {
+	return h.reactToMessage(c, false)
+}
+
+// reactToNote 添加或删除笔记的表情回应
+func (h *NoteHandler) reactToNote(c *fiber.Ctx, add bool) error {
+	userId := c.Locals("userId").(uint)
+
+	var note models.Note
+	if err := h.DB.First(&note, "id = ?", c.Params("id")).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "笔记不存在"})
+	}
+
+	if !models.CanViewNote(h.DB, &note, userId) {
+		return c.Status(403).JSON(fiber.Map{"error": "无权访问该笔记"})
+	}
+
+	emoji, ok := reactionInput(c)
+	if !ok {
+		return c.Status(400).JSON(fiber.Map{"error": "无效的表情"})
+	}
+
+	summaries, changed, err := toggleReaction(h.DB, models.ReactionTargetNote, note.ID, userId, emoji, add)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "更新表情回应失败"})
+	}
+
+	if changed {
+		action := "remove"
+		if add {
+			action = "add"
+		}
+		h.Hub.Publish(websocket.ToNote(note.ID), "reaction", action,
+			reactionEvent(models.ReactionTargetNote, note.ID, note.ChannelID, userId, emoji, summaries))
+	}
+
+	return c.JSON(fiber.Map{"reactions": summaries})
+}
+
+// AddNoteReaction 为笔记添加表情回应
+func (h *NoteHandler) AddNoteReaction(c *fiber.Ctx) error {
+	return h.reactToNote(c, true)
+}
+
+// RemoveNoteReaction 删除自己对笔记的表情回应
+func (h *NoteHandler) RemoveNoteReaction(c *fiber.Ctx) error {
+	return h.reactToNote(c, false)
+}
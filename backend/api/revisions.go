@@ -0,0 +1,261 @@
+package handlers
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/MiXiaoAi/oinote/backend/internal/collab"
+	"github.com/MiXiaoAi/oinote/backend/internal/models"
+	"github.com/MiXiaoAi/oinote/backend/internal/search"
+	"github.com/MiXiaoAi/oinote/backend/internal/websocket"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// 差异比较的最大规模（单侧行数和行数乘积），超过时退化为整体替换
+const (
+	maxDiffLines = 5000
+	maxDiffCells = 1000000
+)
+
+// 块级结束标签后断行，使 HTML 内容按段落比较
+var blockEndRegex = regexp.MustCompile(`(?i)(</(p|h[1-6]|li|ul|ol|blockquote|pre|div|tr|table)>|<br\s*/?>)`)
+
+// DiffLine 表示差异结果中的一行
+type DiffLine struct {
+	Op   string `json:"op"` // equal, insert, delete
+	Text string `json:"text"`
+}
+
+// splitContentLines 将笔记内容拆分为用于比较的行
+func splitContentLines(content string) []string {
+	if content == "" {
+		return []string{}
+	}
+	content = blockEndRegex.ReplaceAllString(content, "$1\n")
+	lines := strings.Split(content, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// diffLines 基于最长公共子序列计算两组行的差异
+// 相同的开头和结尾直接输出，只对中间不同的部分计算，计算表过大时中间部分退化为整体替换
+func diffLines(a, b []string) []DiffLine {
+	result := make([]DiffLine, 0, len(a)+len(b))
+
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	for _, line := range a[:prefix] {
+		result = append(result, DiffLine{Op: "equal", Text: line})
+	}
+	result = diffMiddleLines(result, a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])
+	for _, line := range a[len(a)-suffix:] {
+		result = append(result, DiffLine{Op: "equal", Text: line})
+	}
+	return result
+}
+
+// diffMiddleLines 计算两组行的最长公共子序列差异并追加到 result
+func diffMiddleLines(result []DiffLine, a, b []string) []DiffLine {
+	n, m := len(a), len(b)
+
+	if n > maxDiffLines || m > maxDiffLines || n*m > maxDiffCells {
+		for _, line := range a {
+			result = append(result, DiffLine{Op: "delete", Text: line})
+		}
+		for _, line := range b {
+			result = append(result, DiffLine{Op: "insert", Text: line})
+		}
+		return result
+	}
+
+	// lcs[i][j] 为 a[i:] 与 b[j:] 的最长公共子序列长度
+	lcs := make([][]int, n+1)
+	for i := range lcs {
+		lcs[i] = make([]int, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < n && j < m {
+		switch {
+		case a[i] == b[j]:
+			result = append(result, DiffLine{Op: "equal", Text: a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			result = append(result, DiffLine{Op: "delete", Text: a[i]})
+			i++
+		default:
+			result = append(result, DiffLine{Op: "insert", Text: b[j]})
+			j++
+		}
+	}
+	for ; i < n; i++ {
+		result = append(result, DiffLine{Op: "delete", Text: a[i]})
+	}
+	for ; j < m; j++ {
+		result = append(result, DiffLine{Op: "insert", Text: b[j]})
+	}
+	return result
+}
+
+// loadEditableNote 加载笔记并检查当前用户的编辑权限
+func (h *NoteHandler) loadEditableNote(c *fiber.Ctx) (*models.Note, error) {
+	userId := c.Locals("userId").(uint)
+
+	var note models.Note
+	if err := h.DB.First(&note, c.Params("id")).Error; err != nil {
+		return nil, c.Status(404).JSON(fiber.Map{"error": "笔记不存在"})
+	}
+
+	if !h.canEditNote(&note, userId) {
+		return nil, c.Status(403).JSON(fiber.Map{"error": "无权访问该笔记的历史版本"})
+	}
+
+	return &note, nil
+}
+
+// GetNoteRevisions 获取笔记的历史版本列表（不含内容）
+func (h *NoteHandler) GetNoteRevisions(c *fiber.Ctx) error {
+	note, err := h.loadEditableNote(c)
+	if note == nil {
+		return err
+	}
+
+	var revisions []models.NoteRevision
+	h.DB.Select("id", "created_at", "note_id", "title", "author_id", "source", "restored_from").
+		Preload("Author").
+		Where("note_id = ?", note.ID).
+		Order("id DESC").
+		Find(&revisions)
+
+	return c.JSON(revisions)
+}
+
+// GetNoteRevision 获取单个历史版本
+func (h *NoteHandler) GetNoteRevision(c *fiber.Ctx) error {
+	note, err := h.loadEditableNote(c)
+	if note == nil {
+		return err
+	}
+
+	var revision models.NoteRevision
+	if err := h.DB.Preload("Author").
+		Where("id = ? AND note_id = ?", c.Params("revisionId"), note.ID).
+		First(&revision).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "版本不存在"})
+	}
+
+	return c.JSON(revision)
+}
+
+// DiffNoteRevisions 比较两个版本，to 省略时与笔记当前内容比较
+func (h *NoteHandler) DiffNoteRevisions(c *fiber.Ctx) error {
+	note, err := h.loadEditableNote(c)
+	if note == nil {
+		return err
+	}
+
+	fromID := c.QueryInt("from", 0)
+	toID := c.QueryInt("to", 0)
+	if fromID == 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "缺少起始版本"})
+	}
+
+	var from models.NoteRevision
+	if err := h.DB.Where("id = ? AND note_id = ?", fromID, note.ID).First(&from).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "版本不存在"})
+	}
+
+	toTitle, toContent := note.Title, note.Content
+	if toID != 0 {
+		var to models.NoteRevision
+		if err := h.DB.Where("id = ? AND note_id = ?", toID, note.ID).First(&to).Error; err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": "版本不存在"})
+		}
+		toTitle, toContent = to.Title, to.Content
+	}
+
+	return c.JSON(fiber.Map{
+		"from":          fromID,
+		"to":            toID,
+		"title_changed": from.Title != toTitle,
+		"old_title":     from.Title,
+		"new_title":     toTitle,
+		"lines":         diffLines(splitContentLines(from.Content), splitContentLines(toContent)),
+	})
+}
+
+// RestoreNoteRevision 将笔记恢复到指定版本，同时重置在线协同文档
+func (h *NoteHandler) RestoreNoteRevision(c *fiber.Ctx) error {
+	userId := c.Locals("userId").(uint)
+	note, err := h.loadEditableNote(c)
+	if note == nil {
+		return err
+	}
+
+	var revision models.NoteRevision
+	if err := h.DB.Where("id = ? AND note_id = ?", c.Params("revisionId"), note.ID).First(&revision).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "版本不存在"})
+	}
+
+	// 先写回协同文档中尚未保存的编辑，保证下面保存的当前内容是最新的
+	h.Collab.FlushDocument(note.ID)
+	if err := h.DB.First(note, note.ID).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "笔记不存在"})
+	}
+
+	// 先保存当前内容，恢复操作本身也可以撤销；两个版本和笔记的修改要么都写入，要么都不写入
+	err = h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := collab.RecordRevision(tx, note, userId, models.RevisionSourceSave, nil); err != nil {
+			return err
+		}
+
+		note.Title = revision.Title
+		note.Content = revision.Content
+		if err := tx.Model(note).Updates(map[string]interface{}{
+			"title":   note.Title,
+			"content": note.Content,
+		}).Error; err != nil {
+			return err
+		}
+
+		return collab.RecordRevision(tx, note, userId, models.RevisionSourceRestore, &revision.ID)
+	})
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "恢复版本失败"})
+	}
+
+	search.IndexNote(h.DB, note)
+
+	// 让正在编辑的客户端收敛到恢复后的内容
+	h.Collab.ReplaceContent(note.ID, note.Content)
+
+	// 重新加载完整的笔记信息，包括 Owner
+	h.DB.Preload("Owner").First(note, note.ID)
+
+	// 广播笔记更新消息
+	h.Hub.Publish(websocket.ToNote(note.ID), "note", "update", note)
+
+	return c.JSON(note)
+}
@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"time"
+
+	"github.com/MiXiaoAi/oinote/backend/internal/models"
+	"github.com/MiXiaoAi/oinote/backend/internal/search"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// 搜索分页
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+)
+
+type SearchHandler struct {
+	DB *gorm.DB
+}
+
+func NewSearchHandler(db *gorm.DB) *SearchHandler {
+	return &SearchHandler{DB: db}
+}
+
+// parseSearchQuery 解析搜索参数，并带上当前用户可访问的频道
+func parseSearchQuery(c *fiber.Ctx, db *gorm.DB) (*search.Query, error) {
+	q := search.Query{
+		Text:    c.Query("q"),
+		Type:    c.Query("type"),
+		Tag:     c.Query("tag"),
+		OwnerID: uint(c.QueryInt("owner_id", 0)),
+		Limit:   c.QueryInt("limit", defaultSearchLimit),
+		Offset:  c.QueryInt("offset", 0),
+	}
+
+	if q.Type != "" && q.Type != search.KindNote && q.Type != search.KindMessage {
+		return nil, c.Status(400).JSON(fiber.Map{"error": "无效的搜索类型"})
+	}
+	if q.Limit <= 0 || q.Limit > maxSearchLimit {
+		q.Limit = defaultSearchLimit
+	}
+	if q.Offset < 0 {
+		q.Offset = 0
+	}
+
+	if channelId := c.QueryInt("channel_id", 0); channelId > 0 {
+		id := uint(channelId)
+		q.ChannelID = &id
+	}
+
+	// 日期范围按天筛选，结束日期包含当天
+	if from := c.Query("from"); from != "" {
+		day, err := time.Parse("2006-01-02", from)
+		if err != nil {
+			return nil, c.Status(400).JSON(fiber.Map{"error": "日期格式无效"})
+		}
+		q.From = day.Format("2006-01-02")
+	}
+	if to := c.Query("to"); to != "" {
+		day, err := time.Parse("2006-01-02", to)
+		if err != nil {
+			return nil, c.Status(400).JSON(fiber.Map{"error": "日期格式无效"})
+		}
+		q.To = day.AddDate(0, 0, 1).Format("2006-01-02")
+	}
+
+	if userId := c.Locals("userId"); userId != nil {
+		q.UserID = userId.(uint)
+		db.Model(&models.ChannelMember{}).
+			Where("user_id = ? AND status = ?", q.UserID, models.MemberStatusActive).
+			Pluck("channel_id", &q.ChannelIDs)
+	}
+
+	return &q, nil
+}
+
+// Search 全文搜索笔记和频道消息，按相关度排序并返回高亮摘要
+func (h *SearchHandler) Search(c *fiber.Ctx) error {
+	q, err := parseSearchQuery(c, h.DB)
+	if q == nil {
+		return err
+	}
+
+	results, total, err := search.Search(h.DB, *q)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "搜索失败"})
+	}
+
+	return c.JSON(fiber.Map{
+		"results": results,
+		"total":   total,
+		"limit":   q.Limit,
+		"offset":  q.Offset,
+	})
+}
@@ -0,0 +1,236 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"sync"
+	"time"
+
+	"github.com/MiXiaoAi/oinote/backend/internal/models"
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// 分享链接令牌的随机字节数
+const shareTokenBytes = 24
+
+// newShareToken 生成分享链接的随机令牌
+func newShareToken() (string, error) {
+	b := make([]byte, shareTokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// 分享链接密码尝试限制：窗口内连续输错达到次数后锁定一段时间
+const (
+	shareLinkMaxFailures   = 5
+	shareLinkFailureWindow = 15 * time.Minute
+)
+
+// shareLinkFailures 记录分享链接令牌的密码错误次数
+type shareLinkFailures struct {
+	count int
+	since time.Time // 窗口内第一次输错的时间
+}
+
+var (
+	shareLinkAttempts   = make(map[string]*shareLinkFailures)
+	shareLinkAttemptsMu sync.Mutex
+)
+
+// shareLinkLocked 判断令牌是否因密码错误次数过多而被暂时锁定
+func shareLinkLocked(token string) bool {
+	shareLinkAttemptsMu.Lock()
+	defer shareLinkAttemptsMu.Unlock()
+
+	failures, ok := shareLinkAttempts[token]
+	if !ok {
+		return false
+	}
+	if time.Since(failures.since) >= shareLinkFailureWindow {
+		delete(shareLinkAttempts, token)
+		return false
+	}
+	return failures.count >= shareLinkMaxFailures
+}
+
+// recordShareLinkFailure 记录一次密码错误，同时清理已过期的记录
+func recordShareLinkFailure(token string) {
+	shareLinkAttemptsMu.Lock()
+	defer shareLinkAttemptsMu.Unlock()
+
+	now := time.Now()
+	for t, failures := range shareLinkAttempts {
+		if now.Sub(failures.since) >= shareLinkFailureWindow {
+			delete(shareLinkAttempts, t)
+		}
+	}
+
+	failures, ok := shareLinkAttempts[token]
+	if !ok {
+		failures = &shareLinkFailures{since: now}
+		shareLinkAttempts[token] = failures
+	}
+	failures.count++
+}
+
+// clearShareLinkFailures 密码正确后清除错误记录
+func clearShareLinkFailures(token string) {
+	shareLinkAttemptsMu.Lock()
+	defer shareLinkAttemptsMu.Unlock()
+
+	delete(shareLinkAttempts, token)
+}
+
+// shareLinkUnavailable 分享链接不可用的原因，可用时为空
+func shareLinkUnavailable(link *models.ShareLink) string {
+	switch {
+	case link.RevokedAt != nil:
+		return "分享链接已撤销"
+	case link.ExpiresAt != nil && time.Now().After(*link.ExpiresAt):
+		return "分享链接已过期"
+	case link.MaxViews > 0 && link.ViewCount >= link.MaxViews:
+		return "分享链接的访问次数已用完"
+	}
+	return ""
+}
+
+// CreateShareLink 为笔记创建分享链接，expires_at、password 和 max_views 都是可选的
+func (h *NoteHandler) CreateShareLink(c *fiber.Ctx) error {
+	userId := c.Locals("userId").(uint)
+	note, err := h.loadShareableNote(c)
+	if note == nil {
+		return err
+	}
+
+	var input struct {
+		ExpiresAt *time.Time `json:"expires_at"`
+		Password  string     `json:"password"`
+		MaxViews  int        `json:"max_views"`
+	}
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "输入数据无效"})
+	}
+
+	if input.ExpiresAt != nil && !input.ExpiresAt.After(time.Now()) {
+		return c.Status(400).JSON(fiber.Map{"error": "过期时间必须晚于当前时间"})
+	}
+	if input.MaxViews < 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "访问次数不能为负数"})
+	}
+
+	token, err := newShareToken()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "创建分享链接失败"})
+	}
+
+	link := models.ShareLink{
+		NoteID:    note.ID,
+		CreatorID: userId,
+		Token:     token,
+		ExpiresAt: input.ExpiresAt,
+		MaxViews:  input.MaxViews,
+	}
+	if input.Password != "" {
+		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(input.Password), 10)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "创建分享链接失败"})
+		}
+		link.PasswordHash = string(hashedPassword)
+	}
+
+	if err := h.DB.Create(&link).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "创建分享链接失败"})
+	}
+	link.HasPassword = link.PasswordHash != ""
+
+	return c.Status(201).JSON(link)
+}
+
+// GetShareLinks 获取笔记的分享链接，包括已撤销和已失效的链接
+func (h *NoteHandler) GetShareLinks(c *fiber.Ctx) error {
+	note, err := h.loadShareableNote(c)
+	if note == nil {
+		return err
+	}
+
+	links := []models.ShareLink{}
+	h.DB.Where("note_id = ?", note.ID).Order("id DESC").Find(&links)
+	for i := range links {
+		links[i].HasPassword = links[i].PasswordHash != ""
+	}
+
+	return c.JSON(links)
+}
+
+// RevokeShareLink 撤销分享链接，撤销后链接不能再访问
+func (h *NoteHandler) RevokeShareLink(c *fiber.Ctx) error {
+	note, err := h.loadShareableNote(c)
+	if note == nil {
+		return err
+	}
+
+	var link models.ShareLink
+	if err := h.DB.Where("id = ? AND note_id = ?", c.Params("linkId"), note.ID).First(&link).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "分享链接不存在"})
+	}
+
+	if link.RevokedAt == nil {
+		now := time.Now()
+		if err := h.DB.Model(&link).Update("revoked_at", now).Error; err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "撤销分享链接失败"})
+		}
+	}
+	link.HasPassword = link.PasswordHash != ""
+
+	return c.JSON(link)
+}
+
+// ResolveShareLink 通过分享链接访问笔记，无需登录，只返回该笔记
+// 设置了密码的链接需要在 X-Share-Password 请求头中提供密码，连续输错多次后暂时锁定，每次成功访问计一次访问次数
+func (h *NoteHandler) ResolveShareLink(c *fiber.Ctx) error {
+	var link models.ShareLink
+	if err := h.DB.Where("token = ?", c.Params("token")).First(&link).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "分享链接不存在"})
+	}
+
+	if reason := shareLinkUnavailable(&link); reason != "" {
+		return c.Status(410).JSON(fiber.Map{"error": reason})
+	}
+
+	if link.PasswordHash != "" {
+		password := c.Get("X-Share-Password")
+		if password == "" {
+			return c.Status(401).JSON(fiber.Map{"error": "需要密码", "password_required": true})
+		}
+		if shareLinkLocked(link.Token) {
+			return c.Status(429).JSON(fiber.Map{"error": "密码错误次数过多，请稍后再试", "password_required": true})
+		}
+		if err := bcrypt.CompareHashAndPassword([]byte(link.PasswordHash), []byte(password)); err != nil {
+			recordShareLinkFailure(link.Token)
+			return c.Status(401).JSON(fiber.Map{"error": "密码错误", "password_required": true})
+		}
+		clearShareLinkFailures(link.Token)
+	}
+
+	var note models.Note
+	if err := h.DB.Preload("Owner").First(&note, link.NoteID).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "笔记不存在"})
+	}
+
+	// 并发访问时以数据库中的计数为准，避免超过访问次数
+	result := h.DB.Model(&models.ShareLink{}).
+		Where("id = ? AND (max_views = 0 OR view_count < max_views)", link.ID).
+		UpdateColumn("view_count", gorm.Expr("view_count + 1"))
+	if result.Error != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "访问分享链接失败"})
+	}
+	if result.RowsAffected == 0 {
+		return c.Status(410).JSON(fiber.Map{"error": "分享链接的访问次数已用完"})
+	}
+
+	return c.JSON(note)
+}
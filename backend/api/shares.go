@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"github.com/MiXiaoAi/oinote/backend/internal/models"
+	"github.com/MiXiaoAi/oinote/backend/internal/websocket"
+	"github.com/gofiber/fiber/v2"
+)
+
+// validShareRoles 可以授予的共享角色
+var validShareRoles = map[string]bool{
+	models.ShareRoleViewer:    true,
+	models.ShareRoleCommenter: true,
+	models.ShareRoleEditor:    true,
+}
+
+// loadShareableNote 加载笔记并检查当前用户可以管理其共享
+func (h *NoteHandler) loadShareableNote(c *fiber.Ctx) (*models.Note, error) {
+	userId := c.Locals("userId").(uint)
+
+	var note models.Note
+	if err := h.DB.First(&note, c.Params("id")).Error; err != nil {
+		return nil, c.Status(404).JSON(fiber.Map{"error": "笔记不存在"})
+	}
+	if !h.canManageNote(&note, userId) {
+		return nil, c.Status(403).JSON(fiber.Map{"error": "无权管理该笔记的共享"})
+	}
+	return &note, nil
+}
+
+// GetNoteShares 获取笔记的共享列表，可以编辑笔记的用户可以查看
+func (h *NoteHandler) GetNoteShares(c *fiber.Ctx) error {
+	userId := c.Locals("userId").(uint)
+
+	var note models.Note
+	if err := h.DB.First(&note, c.Params("id")).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "笔记不存在"})
+	}
+	if !h.canEditNote(&note, userId) {
+		return c.Status(403).JSON(fiber.Map{"error": "无权查看该笔记的共享"})
+	}
+
+	shares := []models.NoteShare{}
+	h.DB.Preload("User").Where("note_id = ?", note.ID).Order("id").Find(&shares)
+	return c.JSON(shares)
+}
+
+// ShareNote 把笔记共享给用户，已共享时修改角色
+func (h *NoteHandler) ShareNote(c *fiber.Ctx) error {
+	userId := c.Locals("userId").(uint)
+	note, err := h.loadShareableNote(c)
+	if note == nil {
+		return err
+	}
+
+	var input struct {
+		UserID   uint   `json:"user_id"`
+		Username string `json:"username"`
+		Role     string `json:"role"`
+	}
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "输入数据无效"})
+	}
+
+	if input.Role == "" {
+		input.Role = models.ShareRoleViewer
+	}
+	if !validShareRoles[input.Role] {
+		return c.Status(400).JSON(fiber.Map{"error": "无效的共享角色"})
+	}
+
+	// 共享对象可以通过用户ID或用户名指定
+	var user models.User
+	query := h.DB.Where("id = ?", input.UserID)
+	if input.UserID == 0 {
+		query = h.DB.Where("username = ?", input.Username)
+	}
+	if err := query.First(&user).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "用户不存在"})
+	}
+	if user.ID == note.OwnerID {
+		return c.Status(400).JSON(fiber.Map{"error": "不能共享给笔记所有者"})
+	}
+
+	var share models.NoteShare
+	action := "update"
+	if err := h.DB.Where("note_id = ? AND user_id = ?", note.ID, user.ID).First(&share).Error; err != nil {
+		share = models.NoteShare{NoteID: note.ID, UserID: user.ID, Role: input.Role, GrantedBy: userId}
+		if err := h.DB.Create(&share).Error; err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "共享笔记失败"})
+		}
+		action = "create"
+	} else if share.Role != input.Role {
+		if err := h.DB.Model(&share).Updates(map[string]interface{}{"role": input.Role, "granted_by": userId}).Error; err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "共享笔记失败"})
+		}
+	}
+	share.User = user
+
+	if action == "update" {
+		h.Collab.RefreshUserAccess(note.ID, user.ID)
+	}
+	h.Hub.Publish(websocket.ToUsers(user.ID, note.OwnerID), "share", action, share)
+
+	if action == "create" {
+		return c.Status(201).JSON(share)
+	}
+	return c.JSON(share)
+}
+
+// RevokeNoteShare 取消笔记对用户的共享，被共享的用户也可以自己退出共享
+func (h *NoteHandler) RevokeNoteShare(c *fiber.Ctx) error {
+	userId := c.Locals("userId").(uint)
+
+	var note models.Note
+	if err := h.DB.First(&note, c.Params("id")).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "笔记不存在"})
+	}
+
+	var share models.NoteShare
+	if err := h.DB.Where("note_id = ? AND user_id = ?", note.ID, c.Params("userId")).First(&share).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "该用户没有共享"})
+	}
+	if share.UserID != userId && !h.canManageNote(&note, userId) {
+		return c.Status(403).JSON(fiber.Map{"error": "无权管理该笔记的共享"})
+	}
+
+	if err := h.DB.Delete(&share).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "取消共享失败"})
+	}
+	h.Collab.RefreshUserAccess(note.ID, share.UserID)
+
+	h.Hub.Publish(websocket.ToUsers(share.UserID, note.OwnerID), "share", "delete", fiber.Map{
+		"note_id": note.ID,
+		"user_id": share.UserID,
+	})
+
+	return c.SendStatus(204)
+}
+
+// GetSharedNotes 获取共享给当前用户的笔记
+func (h *NoteHandler) GetSharedNotes(c *fiber.Ctx) error {
+	userId := c.Locals("userId").(uint)
+
+	var shares []models.NoteShare
+	h.DB.Where("user_id = ?", userId).Find(&shares)
+	roles := make(map[uint]string, len(shares))
+	noteIDs := make([]uint, 0, len(shares))
+	for _, share := range shares {
+		roles[share.NoteID] = share.Role
+		noteIDs = append(noteIDs, share.NoteID)
+	}
+
+	notes := []models.Note{}
+	if len(noteIDs) > 0 {
+		h.DB.Preload("Owner").Where("id IN ?", noteIDs).Order("updated_at DESC").Find(&notes)
+	}
+	for i := range notes {
+		notes[i].ShareRole = roles[notes[i].ID]
+	}
+
+	return c.JSON(notes)
+}
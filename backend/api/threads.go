@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"time"
+
+	"github.com/MiXiaoAi/oinote/backend/internal/models"
+	"github.com/MiXiaoAi/oinote/backend/internal/websocket"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// ThreadStats 话题首条消息的回复统计
+type ThreadStats struct {
+	ID          uint       `json:"id"`
+	ChannelID   uint       `json:"channel_id"`
+	ReplyCount  int        `json:"reply_count"`
+	LastReplyAt *time.Time `json:"last_reply_at"`
+}
+
+// updateThreadStats 根据未删除的回复重新计算首条消息的回复数和最后回复时间
+func updateThreadStats(db *gorm.DB, parentID uint) (*ThreadStats, error) {
+	var parent models.ChannelMessage
+	if err := db.Unscoped().First(&parent, parentID).Error; err != nil {
+		return nil, err
+	}
+
+	stats := ThreadStats{ID: parent.ID, ChannelID: parent.ChannelID}
+
+	var count int64
+	db.Model(&models.ChannelMessage{}).Where("parent_id = ?", parentID).Count(&count)
+	stats.ReplyCount = int(count)
+
+	var last models.ChannelMessage
+	if err := db.Where("parent_id = ?", parentID).Order("created_at DESC, id DESC").First(&last).Error; err == nil {
+		stats.LastReplyAt = &last.CreatedAt
+	}
+
+	if err := db.Unscoped().Model(&parent).UpdateColumns(map[string]interface{}{
+		"reply_count":   stats.ReplyCount,
+		"last_reply_at": stats.LastReplyAt,
+	}).Error; err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+// publishThreadStats 更新首条消息的回复统计并通知频道成员
+func publishThreadStats(db *gorm.DB, hub *websocket.Hub, parentID uint) {
+	stats, err := updateThreadStats(db, parentID)
+	if err != nil {
+		return
+	}
+	hub.Publish(websocket.ToChannel(stats.ChannelID), "thread", "update", stats)
+}
+
+func (h *ChannelHandler) publishThreadStats(parentID uint) {
+	publishThreadStats(h.DB, h.Hub, parentID)
+}
+
+// threadRoot 查找频道中的消息所在话题的首条消息，回复的回复归入同一话题
+func (h *ChannelHandler) threadRoot(channelID uint, messageID uint) *models.ChannelMessage {
+	var message models.ChannelMessage
+	if err := h.DB.Where("id = ? AND channel_id = ?", messageID, channelID).First(&message).Error; err != nil {
+		return nil
+	}
+	if message.ParentID == nil {
+		return &message
+	}
+
+	var root models.ChannelMessage
+	if err := h.DB.Where("id = ? AND channel_id = ?", *message.ParentID, channelID).First(&root).Error; err != nil {
+		return nil
+	}
+	return &root
+}
+
+// canViewChannel 公开频道所有人可见，私有频道需要是正式成员
+func (h *ChannelHandler) canViewChannel(c *fiber.Ctx, channel *models.Channel) bool {
+	if channel.IsPublic {
+		return true
+	}
+	userId := c.Locals("userId")
+	if userId == nil {
+		return false
+	}
+
+	return models.IsChannelMember(h.DB, channel.ID, userId.(uint))
+}
+
+// GetThread 获取话题的首条消息和全部回复，传入回复的ID时返回其所在的话题
+func (h *ChannelHandler) GetThread(c *fiber.Ctx) error {
+	var channel models.Channel
+	if err := h.DB.First(&channel, "id = ?", c.Params("id")).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "频道不存在"})
+	}
+
+	if !h.canViewChannel(c, &channel) {
+		return c.Status(403).JSON(fiber.Map{"error": "无权访问该频道"})
+	}
+
+	messageId, err := c.ParamsInt("messageId")
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "无效的消息ID"})
+	}
+
+	root := h.threadRoot(channel.ID, uint(messageId))
+	if root == nil {
+		return c.Status(404).JSON(fiber.Map{"error": "消息不存在"})
+	}
+
+	var parent models.ChannelMessage
+	h.DB.Preload("User").Preload("Attachment").First(&parent, root.ID)
+
+	var replies []models.ChannelMessage
+	h.DB.Preload("User").Preload("Attachment").
+		Where("parent_id = ?", parent.ID).
+		Order("created_at ASC, id ASC").
+		Find(&replies)
+
+	userId := currentUserID(c)
+	attachMessageReactions(h.DB, replies, userId)
+	parent.Reactions = loadReactions(h.DB, models.ReactionTargetMessage, []uint{parent.ID}, userId)[parent.ID]
+
+	return c.JSON(fiber.Map{
+		"parent":  parent,
+		"replies": replies,
+	})
+}
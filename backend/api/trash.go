@@ -0,0 +1,579 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/MiXiaoAi/oinote/backend/internal/collab"
+	"github.com/MiXiaoAi/oinote/backend/internal/links"
+	"github.com/MiXiaoAi/oinote/backend/internal/models"
+	"github.com/MiXiaoAi/oinote/backend/internal/search"
+	"github.com/MiXiaoAi/oinote/backend/internal/websocket"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// 回收站清理任务的执行间隔
+const trashPurgeInterval = time.Hour
+
+type TrashHandler struct {
+	DB     *gorm.DB
+	Hub    *websocket.Hub
+	Collab *collab.YjsServer
+}
+
+func NewTrashHandler(db *gorm.DB, hub *websocket.Hub, yjsServer *collab.YjsServer) *TrashHandler {
+	return &TrashHandler{DB: db, Hub: hub, Collab: yjsServer}
+}
+
+// retentionDays 获取回收站保留天数
+func (h *TrashHandler) retentionDays() int {
+	var config models.TrashConfig
+	if err := h.DB.First(&config, 1).Error; err != nil || config.RetentionDays <= 0 {
+		return models.DefaultTrashRetentionDays
+	}
+	return config.RetentionDays
+}
+
+// isChannelManager 检查用户是否为频道的所有者或管理员
+func (h *TrashHandler) isChannelManager(channelID uint, userId uint) bool {
+	var membership models.ChannelMember
+	err := h.DB.Where("channel_id = ? AND user_id = ? AND status = ? AND (role = ? OR role = ?)",
+		channelID, userId, models.MemberStatusActive, models.RoleOwner, models.RoleAdmin).First(&membership).Error
+	return err == nil
+}
+
+// GetTrash 获取当前用户的回收站（自己删除的笔记和频道）
+func (h *TrashHandler) GetTrash(c *fiber.Ctx) error {
+	userId := c.Locals("userId").(uint)
+
+	// 随频道或文件夹一起删除的笔记在恢复频道或文件夹时一并恢复，这里不单独列出
+	var notes []models.Note
+	h.DB.Unscoped().Preload("Owner").
+		Where("owner_id = ? AND deleted_at IS NOT NULL", userId).
+		Where("channel_id IS NULL OR channel_id IN (?)", h.DB.Model(&models.Channel{}).Select("id")).
+		Where("folder_id IS NULL OR folder_id IN (?)", h.DB.Model(&models.Folder{}).Select("id")).
+		Order("deleted_at DESC").
+		Find(&notes)
+
+	var channels []models.Channel
+	h.DB.Unscoped().Preload("Owner").
+		Where("owner_id = ? AND deleted_at IS NOT NULL", userId).
+		Order("deleted_at DESC").
+		Find(&channels)
+
+	// 个人文件夹，随上级文件夹一起删除的子文件夹不单独列出
+	var folders []models.Folder
+	h.DB.Unscoped().
+		Where("owner_id = ? AND channel_id IS NULL AND deleted_at IS NOT NULL", userId).
+		Where("parent_id IS NULL OR parent_id IN (?)", h.DB.Model(&models.Folder{}).Select("id")).
+		Order("deleted_at DESC").
+		Find(&folders)
+
+	return c.JSON(fiber.Map{
+		"notes":          notes,
+		"channels":       channels,
+		"folders":        folders,
+		"retention_days": h.retentionDays(),
+	})
+}
+
+// GetChannelTrash 获取频道回收站，管理员可以看到所有内容，普通成员只能看到自己的
+func (h *TrashHandler) GetChannelTrash(c *fiber.Ctx) error {
+	userId := c.Locals("userId").(uint)
+	channelId := c.Params("id")
+
+	var membership models.ChannelMember
+	if err := h.DB.Where("channel_id = ? AND user_id = ? AND status = ?", channelId, userId, models.MemberStatusActive).
+		First(&membership).Error; err != nil {
+		return c.Status(403).JSON(fiber.Map{"error": "无权访问该频道"})
+	}
+
+	var channel models.Channel
+	if err := h.DB.First(&channel, "id = ?", channelId).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "频道不存在"})
+	}
+
+	notesQuery := h.DB.Unscoped().Preload("Owner").
+		Where("channel_id = ? AND deleted_at IS NOT NULL", channel.ID).
+		Where("folder_id IS NULL OR folder_id IN (?)", h.DB.Model(&models.Folder{}).Select("id"))
+	messagesQuery := h.DB.Unscoped().Preload("User").Preload("Attachment").
+		Where("channel_id = ? AND deleted_at IS NOT NULL", channel.ID)
+	foldersQuery := h.DB.Unscoped().
+		Where("channel_id = ? AND deleted_at IS NOT NULL", channel.ID).
+		Where("parent_id IS NULL OR parent_id IN (?)", h.DB.Model(&models.Folder{}).Select("id"))
+
+	if membership.Role != models.RoleOwner && membership.Role != models.RoleAdmin {
+		notesQuery = notesQuery.Where("owner_id = ?", userId)
+		messagesQuery = messagesQuery.Where("user_id = ?", userId)
+		foldersQuery = foldersQuery.Where("owner_id = ?", userId)
+	}
+
+	var notes []models.Note
+	notesQuery.Order("deleted_at DESC").Find(&notes)
+
+	var messages []models.ChannelMessage
+	messagesQuery.Order("deleted_at DESC").Find(&messages)
+
+	var folders []models.Folder
+	foldersQuery.Order("deleted_at DESC").Find(&folders)
+
+	return c.JSON(fiber.Map{
+		"notes":          notes,
+		"messages":       messages,
+		"folders":        folders,
+		"retention_days": h.retentionDays(),
+	})
+}
+
+// RestoreNote 从回收站恢复笔记
+func (h *TrashHandler) RestoreNote(c *fiber.Ctx) error {
+	userId := c.Locals("userId").(uint)
+	noteId := c.Params("id")
+
+	var note models.Note
+	if err := h.DB.Unscoped().Where("id = ? AND deleted_at IS NOT NULL", noteId).First(&note).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "回收站中没有该笔记"})
+	}
+
+	// 笔记所有者可以恢复，频道笔记的管理员也可以恢复
+	if note.OwnerID != userId && (note.ChannelID == nil || !h.isChannelManager(*note.ChannelID, userId)) {
+		return c.Status(403).JSON(fiber.Map{"error": "无权恢复该笔记"})
+	}
+
+	if note.ChannelID != nil {
+		var channel models.Channel
+		if err := h.DB.First(&channel, *note.ChannelID).Error; err != nil {
+			return c.Status(409).JSON(fiber.Map{"error": "所属频道已删除，请先恢复频道"})
+		}
+	}
+
+	if note.FolderID != nil {
+		var folder models.Folder
+		if err := h.DB.First(&folder, *note.FolderID).Error; err != nil {
+			return c.Status(409).JSON(fiber.Map{"error": "所在文件夹已删除，请先恢复文件夹"})
+		}
+	}
+
+	if err := h.DB.Unscoped().Model(&note).Update("deleted_at", nil).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "恢复笔记失败"})
+	}
+
+	// 重新加载完整的笔记信息，包括 Owner
+	h.DB.Preload("Owner").First(&note, note.ID)
+	links.Relink(h.DB, &note, note.Title)
+
+	// 恢复的笔记按新建广播，客户端会重新加入列表
+	h.Hub.Publish(websocket.ToNote(note.ID), "note", "create", note)
+
+	return c.JSON(note)
+}
+
+// RestoreChannel 从回收站恢复频道，随频道一起删除的笔记和消息一并恢复
+func (h *TrashHandler) RestoreChannel(c *fiber.Ctx) error {
+	userId := c.Locals("userId").(uint)
+	channelId := c.Params("id")
+
+	var channel models.Channel
+	if err := h.DB.Unscoped().Where("id = ? AND owner_id = ? AND deleted_at IS NOT NULL", channelId, userId).
+		First(&channel).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "回收站中没有该频道或无权恢复"})
+	}
+
+	// 删除频道之前就已在回收站中的笔记和消息保持删除状态
+	deletedAt := channel.DeletedAt.Time
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Model(&models.Note{}).
+			Where("channel_id = ? AND deleted_at >= ?", channel.ID, deletedAt).
+			Update("deleted_at", nil).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Unscoped().Model(&models.ChannelMessage{}).
+			Where("channel_id = ? AND deleted_at >= ?", channel.ID, deletedAt).
+			Update("deleted_at", nil).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Unscoped().Model(&models.Folder{}).
+			Where("channel_id = ? AND deleted_at >= ?", channel.ID, deletedAt).
+			Update("deleted_at", nil).Error; err != nil {
+			return err
+		}
+
+		return tx.Unscoped().Model(&channel).Update("deleted_at", nil).Error
+	})
+
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "恢复频道失败"})
+	}
+
+	// 重新加载完整的频道信息，包括 Owner
+	h.DB.Preload("Owner").First(&channel, channel.ID)
+
+	// 恢复的频道按新建广播，客户端会重新加入列表
+	h.Hub.Publish(websocket.ToChannel(channel.ID), "channel", "create", channel)
+
+	return c.JSON(channel)
+}
+
+// RestoreFolder 从回收站恢复文件夹，与其一起删除的子文件夹和笔记一并恢复
+func (h *TrashHandler) RestoreFolder(c *fiber.Ctx) error {
+	userId := c.Locals("userId").(uint)
+
+	var folder models.Folder
+	if err := h.DB.Unscoped().Where("id = ? AND deleted_at IS NOT NULL", c.Params("id")).First(&folder).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "回收站中没有该文件夹"})
+	}
+
+	// 文件夹创建者可以恢复，频道文件夹的管理员也可以恢复
+	if folder.OwnerID != userId && (folder.ChannelID == nil || !h.isChannelManager(*folder.ChannelID, userId)) {
+		return c.Status(403).JSON(fiber.Map{"error": "无权恢复该文件夹"})
+	}
+
+	if folder.ChannelID != nil {
+		var channel models.Channel
+		if err := h.DB.First(&channel, *folder.ChannelID).Error; err != nil {
+			return c.Status(409).JSON(fiber.Map{"error": "所属频道已删除，请先恢复频道"})
+		}
+	}
+
+	if folder.ParentID != nil {
+		var parent models.Folder
+		if err := h.DB.First(&parent, *folder.ParentID).Error; err != nil {
+			return c.Status(409).JSON(fiber.Map{"error": "上级文件夹已删除，请先恢复上级文件夹"})
+		}
+	}
+
+	// 删除文件夹之前就已在回收站中的子文件夹和笔记保持删除状态
+	deletedAt := folder.DeletedAt.Time
+	folderIDs := folderSubtree(h.DB, folder.ID, true)
+	var noteIDs []uint
+	h.DB.Unscoped().Model(&models.Note{}).Where("folder_id IN ? AND deleted_at >= ?", folderIDs, deletedAt).Pluck("id", &noteIDs)
+
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Model(&models.Note{}).Where("id IN ?", noteIDs).Update("deleted_at", nil).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Model(&models.Folder{}).
+			Where("id IN ? AND deleted_at >= ?", folderIDs, deletedAt).
+			Update("deleted_at", nil).Error
+	})
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "恢复文件夹失败"})
+	}
+
+	h.DB.First(&folder, folder.ID)
+
+	// 恢复的文件夹和笔记按新建广播，客户端会重新加入列表
+	h.Hub.Publish(folderAudience(&folder), "folder", "create", folder)
+	for _, noteID := range noteIDs {
+		var note models.Note
+		if err := h.DB.Preload("Owner").First(&note, noteID).Error; err == nil {
+			links.Relink(h.DB, &note, note.Title)
+			h.Hub.Publish(websocket.ToNote(note.ID), "note", "create", note)
+		}
+	}
+
+	return c.JSON(folder)
+}
+
+// RestoreChannelMessage 从回收站恢复频道消息
+func (h *TrashHandler) RestoreChannelMessage(c *fiber.Ctx) error {
+	userId := c.Locals("userId").(uint)
+	channelId := c.Params("id")
+	messageId := c.Params("messageId")
+
+	var membership models.ChannelMember
+	if err := h.DB.Where("channel_id = ? AND user_id = ? AND status = ?", channelId, userId, models.MemberStatusActive).
+		First(&membership).Error; err != nil {
+		return c.Status(403).JSON(fiber.Map{"error": "无权访问该频道"})
+	}
+
+	var channel models.Channel
+	if err := h.DB.First(&channel, "id = ?", channelId).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "频道不存在"})
+	}
+
+	var message models.ChannelMessage
+	if err := h.DB.Unscoped().Where("id = ? AND channel_id = ? AND deleted_at IS NOT NULL", messageId, channel.ID).
+		First(&message).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "回收站中没有该消息"})
+	}
+
+	// 只有消息发送者或管理员/所有者可以恢复
+	if message.UserID != userId && membership.Role != models.RoleAdmin && membership.Role != models.RoleOwner {
+		return c.Status(403).JSON(fiber.Map{"error": "只能恢复自己的消息"})
+	}
+
+	// 话题首条消息仍在回收站时不能单独恢复回复
+	if message.ParentID != nil {
+		var parent models.ChannelMessage
+		if err := h.DB.First(&parent, *message.ParentID).Error; err != nil {
+			return c.Status(409).JSON(fiber.Map{"error": "所属话题已被删除，请先恢复话题的首条消息"})
+		}
+	}
+
+	// 恢复首条消息时，与其一起删除的回复一并恢复，之前单独删除的回复保持删除状态
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		if message.ParentID == nil {
+			if err := tx.Unscoped().Model(&models.ChannelMessage{}).
+				Where("parent_id = ? AND deleted_at >= ?", message.ID, message.DeletedAt.Time).
+				Update("deleted_at", nil).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Unscoped().Model(&message).Update("deleted_at", nil).Error
+	})
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "恢复消息失败"})
+	}
+
+	h.DB.Preload("User").Preload("Attachment").First(&message, message.ID)
+
+	// 恢复的消息按新消息广播，回复按话题回复广播
+	if message.ParentID != nil {
+		h.Hub.Publish(websocket.ToChannel(message.ChannelID), "message", "reply", message)
+		publishThreadStats(h.DB, h.Hub, *message.ParentID)
+	} else {
+		h.Hub.Publish(websocket.ToChannel(message.ChannelID), "message", "create", message)
+	}
+
+	return c.JSON(message)
+}
+
+// GetTrashConfig 获取回收站配置（仅管理员）
+func (h *TrashHandler) GetTrashConfig(c *fiber.Ctx) error {
+	var config models.TrashConfig
+	// 回收站配置只有一条记录，ID 为 1
+	if err := h.DB.First(&config, 1).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			// 如果不存在，返回默认配置
+			return c.JSON(models.TrashConfig{
+				ID:            1,
+				RetentionDays: models.DefaultTrashRetentionDays,
+			})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": "获取配置失败"})
+	}
+	return c.JSON(config)
+}
+
+// UpdateTrashConfig 更新回收站配置（仅管理员）
+func (h *TrashHandler) UpdateTrashConfig(c *fiber.Ctx) error {
+	userId := c.Locals("userId").(uint)
+
+	type UpdateTrashConfigInput struct {
+		RetentionDays *int `json:"retention_days"`
+	}
+
+	var input UpdateTrashConfigInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "输入数据无效"})
+	}
+
+	var config models.TrashConfig
+	if err := h.DB.First(&config, 1).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			// 如果不存在，创建新配置
+			config = models.TrashConfig{ID: 1, RetentionDays: models.DefaultTrashRetentionDays}
+		} else {
+			return c.Status(500).JSON(fiber.Map{"error": "更新配置失败"})
+		}
+	}
+
+	if input.RetentionDays != nil {
+		if *input.RetentionDays < 1 {
+			return c.Status(400).JSON(fiber.Map{"error": "保留天数至少为1天"})
+		}
+		config.RetentionDays = *input.RetentionDays
+	}
+	config.UpdatedBy = userId
+
+	if err := h.DB.Save(&config).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "更新配置失败"})
+	}
+
+	return c.JSON(config)
+}
+
+// PurgeExpired 定期彻底清除超过保留期限的回收站内容及其附件文件
+func (h *TrashHandler) PurgeExpired() {
+	ticker := time.NewTicker(trashPurgeInterval)
+	defer ticker.Stop()
+
+	for {
+		h.purgeExpired()
+		<-ticker.C
+	}
+}
+
+// purgeExpired 执行一次回收站清理
+func (h *TrashHandler) purgeExpired() {
+	cutoff := time.Now().AddDate(0, 0, -h.retentionDays())
+
+	// 先清理频道，其中的笔记和消息一并清除
+	var channels []models.Channel
+	h.DB.Unscoped().Where("deleted_at IS NOT NULL AND deleted_at < ?", cutoff).Find(&channels)
+	for i := range channels {
+		h.purgeChannel(&channels[i])
+	}
+
+	// 再清理文件夹，其中的笔记一并清除
+	var folders []models.Folder
+	h.DB.Unscoped().Where("deleted_at IS NOT NULL AND deleted_at < ?", cutoff).Find(&folders)
+	for i := range folders {
+		h.purgeFolder(&folders[i])
+	}
+
+	var notes []models.Note
+	h.DB.Unscoped().Where("deleted_at IS NOT NULL AND deleted_at < ?", cutoff).Find(&notes)
+	for i := range notes {
+		h.purgeNote(&notes[i])
+	}
+
+	var messages []models.ChannelMessage
+	h.DB.Unscoped().Where("deleted_at IS NOT NULL AND deleted_at < ?", cutoff).Find(&messages)
+	for i := range messages {
+		h.purgeMessage(&messages[i])
+	}
+
+	if len(channels)+len(folders)+len(notes)+len(messages) > 0 {
+		log.Printf("回收站清理完成: 频道 %d 个, 文件夹 %d 个, 笔记 %d 篇, 消息 %d 条", len(channels), len(folders), len(notes), len(messages))
+	}
+}
+
+// removeAttachmentFile 删除附件对应的文件
+func removeAttachmentFile(attachment models.Attachment) {
+	if attachment.FilePath != "" {
+		fullPath := filepath.Join("./data", attachment.FilePath)
+		os.Remove(fullPath)
+	}
+}
+
+// purgeNote 彻底删除笔记及其附件、协同文档和历史版本
+func (h *TrashHandler) purgeNote(note *models.Note) {
+	// 获取该笔记的所有附件
+	var attachments []models.Attachment
+	h.DB.Where("note_id = ?", note.ID).Find(&attachments)
+
+	// 删除所有附件文件
+	for _, attachment := range attachments {
+		removeAttachmentFile(attachment)
+	}
+
+	// 删除附件记录
+	h.DB.Where("note_id = ?", note.ID).Delete(&models.Attachment{})
+
+	// 删除笔记目录
+	noteDir := filepath.Join("./data/uploads/notes", fmt.Sprintf("note_%d", note.ID))
+	os.RemoveAll(noteDir)
+
+	// 删除协同文档和历史版本，避免ID被复用时恢复旧内容
+	h.Collab.DeleteDocument(note.ID)
+	h.DB.Where("note_id = ?", note.ID).Delete(&models.NoteRevision{})
+	deleteReactions(h.DB, models.ReactionTargetNote, note.ID)
+	h.DB.Where("note_id = ?", note.ID).Delete(&models.Notification{})
+	h.DB.Where("note_id = ?", note.ID).Delete(&models.NoteShare{})
+	h.DB.Where("note_id = ?", note.ID).Delete(&models.ShareLink{})
+	h.DB.Where("note_id = ?", note.ID).Delete(&models.NoteComment{})
+	search.RemoveNote(h.DB, note.ID)
+	links.Remove(h.DB, note.ID)
+
+	if err := h.DB.Unscoped().Delete(note).Error; err != nil {
+		log.Printf("清除笔记失败: noteID=%d, err=%v", note.ID, err)
+	}
+}
+
+// purgeFolder 彻底删除文件夹及其中回收站里的笔记，其中未删除的笔记和子文件夹移到顶层
+func (h *TrashHandler) purgeFolder(folder *models.Folder) {
+	var notes []models.Note
+	h.DB.Unscoped().Where("folder_id = ? AND deleted_at IS NOT NULL", folder.ID).Find(&notes)
+	for i := range notes {
+		h.purgeNote(&notes[i])
+	}
+	h.DB.Model(&models.Note{}).Where("folder_id = ?", folder.ID).Update("folder_id", nil)
+	h.DB.Model(&models.Folder{}).Where("parent_id = ?", folder.ID).Update("parent_id", nil)
+
+	if err := h.DB.Unscoped().Delete(folder).Error; err != nil {
+		log.Printf("清除文件夹失败: folderID=%d, err=%v", folder.ID, err)
+	}
+}
+
+// purgeMessage 彻底删除频道消息及其附件
+func (h *TrashHandler) purgeMessage(message *models.ChannelMessage) {
+	if message.AttachmentID != nil {
+		var attachment models.Attachment
+		if err := h.DB.First(&attachment, *message.AttachmentID).Error; err == nil {
+			removeAttachmentFile(attachment)
+			h.DB.Delete(&attachment)
+		}
+	}
+
+	h.DB.Where("message_id = ?", message.ID).Delete(&models.ChannelMessageEdit{})
+	deleteReactions(h.DB, models.ReactionTargetMessage, message.ID)
+	h.DB.Where("message_id = ?", message.ID).Delete(&models.Notification{})
+
+	if err := h.DB.Unscoped().Delete(message).Error; err != nil {
+		log.Printf("清除消息失败: messageID=%d, err=%v", message.ID, err)
+	}
+	search.RemoveMessage(h.DB, message.ID)
+}
+
+// purgeChannel 彻底删除频道及其成员、笔记、消息和附件
+func (h *TrashHandler) purgeChannel(channel *models.Channel) {
+	var notes []models.Note
+	h.DB.Unscoped().Where("channel_id = ?", channel.ID).Find(&notes)
+	for i := range notes {
+		h.purgeNote(&notes[i])
+	}
+
+	var messageIDs []uint
+	h.DB.Unscoped().Model(&models.ChannelMessage{}).Where("channel_id = ?", channel.ID).Pluck("id", &messageIDs)
+	for _, messageID := range messageIDs {
+		search.RemoveMessage(h.DB, messageID)
+	}
+	deleteReactions(h.DB, models.ReactionTargetMessage, messageIDs...)
+	h.DB.Where("channel_id = ?", channel.ID).Delete(&models.Notification{})
+
+	// 删除频道附件文件（消息附件等）
+	var attachments []models.Attachment
+	h.DB.Where("channel_id = ?", channel.ID).Find(&attachments)
+	for _, attachment := range attachments {
+		removeAttachmentFile(attachment)
+	}
+
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		// 使用原生SQL确保彻底删除
+		if err := tx.Exec("DELETE FROM channel_members WHERE channel_id = ?", channel.ID).Error; err != nil {
+			return err
+		}
+		if err := tx.Exec("DELETE FROM channel_message_edits WHERE channel_id = ?", channel.ID).Error; err != nil {
+			return err
+		}
+		if err := tx.Exec("DELETE FROM channel_read_states WHERE channel_id = ?", channel.ID).Error; err != nil {
+			return err
+		}
+		if err := tx.Exec("DELETE FROM folders WHERE channel_id = ?", channel.ID).Error; err != nil {
+			return err
+		}
+		if err := tx.Exec("DELETE FROM channel_messages WHERE channel_id = ?", channel.ID).Error; err != nil {
+			return err
+		}
+		if err := tx.Exec("DELETE FROM attachments WHERE channel_id = ?", channel.ID).Error; err != nil {
+			return err
+		}
+		return tx.Exec("DELETE FROM channels WHERE id = ?", channel.ID).Error
+	})
+	if err != nil {
+		log.Printf("清除频道失败: channelID=%d, err=%v", channel.ID, err)
+		return
+	}
+
+	// 删除频道目录
+	channelDir := filepath.Join("./data/uploads/channels", fmt.Sprintf("channel_%d", channel.ID))
+	os.RemoveAll(channelDir)
+}
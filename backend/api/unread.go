@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"github.com/MiXiaoAi/oinote/backend/internal/models"
+	"github.com/MiXiaoAi/oinote/backend/internal/websocket"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// UnreadState 用户在某个频道的未读状态
+type UnreadState struct {
+	ChannelID         uint  `json:"channel_id"`
+	UserID            uint  `json:"-"`
+	UnreadCount       int   `json:"unread_count"`
+	MentionCount      int   `json:"mention_count"`
+	LastReadMessageID *uint `json:"last_read_message_id"`
+}
+
+// ChannelWithUnread 带未读数的频道
+type ChannelWithUnread struct {
+	models.Channel
+	UnreadCount       int   `json:"unread_count"`
+	MentionCount      int   `json:"mention_count"`
+	LastReadMessageID *uint `json:"last_read_message_id"`
+}
+
+// unreadSQL 统计频道成员的未读消息数和提及数，提及数为未读消息中提及该成员的条数
+// 有已读记录时统计已读消息之后的消息，否则统计加入频道之后的消息；自己发送的消息和系统消息（user_id 为 NULL）不计入
+// created_at 和 joined_at 都以 datetime('now') 的格式存储，可以直接比较
+// 已读消息被彻底删除后无法比较位置，此时不计未读，直到再次标记已读
+const unreadSQL = `SELECT cm.channel_id AS channel_id, cm.user_id AS user_id, rs.last_read_message_id AS last_read_message_id,
+	COUNT(m.id) AS unread_count,
+	COALESCE(SUM(CASE WHEN EXISTS (SELECT 1 FROM notifications n WHERE n.user_id = cm.user_id AND n.message_id = m.id
+		AND n.type = '` + models.NotificationMention + `') THEN 1 ELSE 0 END), 0) AS mention_count
+FROM channel_members cm
+LEFT JOIN channel_read_states rs ON rs.user_id = cm.user_id AND rs.channel_id = cm.channel_id
+LEFT JOIN channel_messages m ON m.channel_id = cm.channel_id AND m.deleted_at IS NULL AND m.user_id <> cm.user_id
+	AND (CASE WHEN rs.id IS NULL THEN m.created_at >= cm.joined_at
+		ELSE (m.created_at, m.id) > (SELECT r.created_at, r.id FROM channel_messages r WHERE r.id = rs.last_read_message_id) END)
+WHERE cm.status = ? AND `
+
+// unreadStates 查询满足条件的成员记录的未读状态
+func unreadStates(db *gorm.DB, condition string, args ...interface{}) []UnreadState {
+	var states []UnreadState
+	query := unreadSQL + condition + " GROUP BY cm.channel_id, cm.user_id"
+	db.Raw(query, append([]interface{}{models.MemberStatusActive}, args...)...).Scan(&states)
+	return states
+}
+
+// publishUnread 把频道成员的最新未读状态推送给各自的所有设备，exceptUserID 为不需要通知的用户（如消息发送者）
+func publishUnread(db *gorm.DB, hub *websocket.Hub, channelID uint, exceptUserID uint) {
+	for _, state := range unreadStates(db, "cm.channel_id = ? AND cm.user_id <> ?", channelID, exceptUserID) {
+		hub.Publish(websocket.ToUsers(state.UserID), "unread", "update", state)
+	}
+}
+
+// MarkChannelRead 把频道标记为已读到指定消息，未指定时标记到最新消息
+func (h *ChannelHandler) MarkChannelRead(c *fiber.Ctx) error {
+	membership, err := h.requireMembership(c)
+	if membership == nil {
+		return err
+	}
+
+	var input struct {
+		MessageID uint `json:"message_id"`
+	}
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&input); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "输入数据无效"})
+		}
+	}
+
+	var message models.ChannelMessage
+	if input.MessageID != 0 {
+		if err := h.DB.Unscoped().Where("id = ? AND channel_id = ?", input.MessageID, membership.ChannelID).
+			First(&message).Error; err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": "消息不存在"})
+		}
+	} else if err := h.DB.Where("channel_id = ?", membership.ChannelID).
+		Order("created_at DESC, id DESC").First(&message).Error; err != nil {
+		// 频道还没有消息，无需标记
+		return c.JSON(UnreadState{ChannelID: membership.ChannelID})
+	}
+
+	readState := models.ChannelReadState{
+		UserID:            membership.UserID,
+		ChannelID:         membership.ChannelID,
+		LastReadMessageID: message.ID,
+	}
+	if err := h.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "channel_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"last_read_message_id", "updated_at"}),
+	}).Create(&readState).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "标记已读失败"})
+	}
+
+	state := UnreadState{ChannelID: membership.ChannelID, UserID: membership.UserID}
+	if states := unreadStates(h.DB, "cm.channel_id = ? AND cm.user_id = ?", membership.ChannelID, membership.UserID); len(states) > 0 {
+		state = states[0]
+	}
+
+	// 同步到该用户的其他设备
+	h.Hub.Publish(websocket.ToUsers(membership.UserID), "unread", "update", state)
+
+	return c.JSON(state)
+}
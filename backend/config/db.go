@@ -4,7 +4,9 @@ import (
 	"log"
 	"os"
 
+	"github.com/MiXiaoAi/oinote/backend/internal/links"
 	"github.com/MiXiaoAi/oinote/backend/internal/models"
+	"github.com/MiXiaoAi/oinote/backend/internal/search"
 	"github.com/glebarez/sqlite"
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
@@ -25,6 +27,9 @@ func Connect() error {
 		return err
 	}
 
+	// 笔记引用表是新建的时候，需要从现有笔记内容中提取引用
+	hasNoteLinks := DB.Migrator().HasTable(&models.NoteLink{})
+
 	// 自动迁移
 	err = DB.AutoMigrate(
 		&models.User{},
@@ -33,12 +38,38 @@ func Connect() error {
 		&models.Note{},
 		&models.Attachment{},
 		&models.ChannelMessage{},
+		&models.ChannelMessageEdit{},
 		&models.AIConfig{},
+		&models.NoteYDoc{},
+		&models.NoteYUpdate{},
+		&models.NoteRevision{},
+		&models.TrashConfig{},
+		&models.Reaction{},
+		&models.ChannelReadState{},
+		&models.Notification{},
+		&models.Folder{},
+		&models.NoteLink{},
+		&models.NoteShare{},
+		&models.ShareLink{},
+		&models.NoteComment{},
 	)
 	if err != nil {
 		return err
 	}
 
+	// 频道消息按 created_at 的存储值排序和分页、按 joined_at 统计未读，统一旧版本用 GORM 写入的时间格式
+	DB.Exec("UPDATE channel_messages SET created_at = datetime(created_at) WHERE created_at <> datetime(created_at)")
+	DB.Exec("UPDATE channel_members SET joined_at = datetime(joined_at) WHERE joined_at <> datetime(joined_at)")
+
+	// 全文搜索索引
+	if err := search.Setup(DB); err != nil {
+		return err
+	}
+
+	if !hasNoteLinks {
+		links.Rebuild(DB)
+	}
+
 	// 创建默认 admin 用户（如果用户表为空）
 	var userCount int64
 	DB.Model(&models.User{}).Count(&userCount)
@@ -0,0 +1,150 @@
+package collab
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+
+	y "github.com/skyterra/y-crdt"
+)
+
+// 锚点所在的共享文本
+const contentTextName = "content"
+
+// CreateAnchor 把协同文档内容中的范围 [from, to) 转换为编码后的 Yjs 相对位置
+// 位置按 UTF-16 计数，与客户端 Y.Text 的索引一致；范围无效时 ok 为 false
+func (s *YjsServer) CreateAnchor(noteID uint, initialContent string, from, to int) (start, end []byte, ok bool) {
+	doc := s.GetOrCreateDocument(noteID, initialContent)
+
+	doc.mu.RLock()
+	defer doc.mu.RUnlock()
+
+	text := doc.Doc.GetText(contentTextName)
+	if from < 0 || to <= from || to > text.Length() {
+		return nil, nil, false
+	}
+
+	// 起点关联到范围内的第一个字符，终点关联到范围内的最后一个字符，范围两侧插入的文字不会被包含进来
+	start = y.EncodeRelativePosition(y.NewRelativePositionFromTypeIndex(text, from, 0))
+	end = y.EncodeRelativePosition(y.NewRelativePositionFromTypeIndex(text, to, -1))
+	return start, end, true
+}
+
+// ResolveAnchor 把编码后的相对位置转换为当前内容中的范围
+// 锚定的文字全部被删除时 from 等于 to；锚点无法解析或笔记没有协同数据时 ok 为 false
+func (s *YjsServer) ResolveAnchor(noteID uint, start, end []byte) (from, to int, ok bool) {
+	return s.ReadDocument(noteID).ResolveAnchor(start, end)
+}
+
+// ResolveAnchor 把编码后的相对位置转换为文档内容中的范围，文档为 nil 时 ok 为 false
+func (d *YjsDocument) ResolveAnchor(start, end []byte) (from, to int, ok bool) {
+	if d == nil {
+		return 0, 0, false
+	}
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	from, ok = absoluteIndex(d.Doc, start)
+	if !ok {
+		return 0, 0, false
+	}
+	to, ok = absoluteIndex(d.Doc, end)
+	if !ok {
+		return 0, 0, false
+	}
+	if to < from {
+		to = from
+	}
+	return from, to, true
+}
+
+// ReadDocument 获取只读查询使用的文档：已加载时直接使用，否则从数据库临时恢复一份，
+// 不放入内存也不保存初始快照；笔记没有持久化的协同数据时返回 nil
+func (s *YjsServer) ReadDocument(noteID uint) *YjsDocument {
+	if doc := s.getDocument(noteID); doc != nil {
+		return doc
+	}
+
+	doc := &YjsDocument{
+		Doc:    y.NewDoc(fmt.Sprintf("%d", noteID), false, nil, nil, false),
+		noteID: noteID,
+	}
+	if !s.loadDocument(noteID, doc) {
+		return nil
+	}
+	return doc
+}
+
+// absoluteIndex 计算相对位置在内容文本中的索引，调用方需持有 doc.mu
+func absoluteIndex(doc *y.Doc, encoded []byte) (index int, ok bool) {
+	// 客户端提交的数据可能无法解码，y-crdt 遇到无效数据会 panic
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("解析评论锚点失败: %v", r)
+			index, ok = 0, false
+		}
+	}()
+
+	rpos, ok := decodeRelativePosition(encoded)
+	if !ok {
+		return 0, false
+	}
+	text := doc.GetText(contentTextName)
+
+	// 位于根文本开头或末尾的位置只记录了文本名称，y-crdt 按 Map 查找根类型会失败，在这里直接计算
+	if rpos.Item == nil {
+		if rpos.Tname != contentTextName {
+			return 0, false
+		}
+		if rpos.Assoc >= 0 {
+			return text.Length(), true
+		}
+		return 0, true
+	}
+
+	abs := y.CreateAbsolutePositionFromRelativePosition(rpos, doc)
+	if abs == nil || abs.Type != y.IAbstractType(text) {
+		return 0, false
+	}
+	return abs.Index, true
+}
+
+// decodeRelativePosition 解码 Yjs 相对位置
+// y-crdt 的 DecodeRelativePosition 无法识别位置类型，解码结果总是无效，这里按 Yjs 的编码格式自行解码
+func decodeRelativePosition(encoded []byte) (*y.RelativePosition, bool) {
+	if len(encoded) == 0 {
+		return nil, false
+	}
+	decoder := y.NewUpdateDecoderV1(encoded)
+	kind, err := binary.ReadUvarint(decoder.RestDecoder)
+	if err != nil {
+		return nil, false
+	}
+
+	rpos := &y.RelativePosition{}
+	switch kind {
+	case 0:
+		// 位于某个字符上
+		rpos.Item, err = decoder.ReadID()
+	case 1:
+		// 位于根类型的末尾，记录的是类型名称
+		rpos.Tname, err = decoder.ReadString()
+	default:
+		// 内容文本是根类型，不会出现嵌套类型中的位置
+		return nil, false
+	}
+	if err != nil {
+		return nil, false
+	}
+
+	if decoder.RestDecoder.Len() > 0 {
+		v, err := y.ReadVarInt(decoder.RestDecoder)
+		assoc, isNumber := v.(y.Number)
+		if err != nil || !isNumber {
+			return nil, false
+		}
+		rpos.Assoc = assoc
+	}
+	return rpos, true
+}
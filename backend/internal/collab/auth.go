@@ -0,0 +1,114 @@
+package collab
+
+import (
+	"log"
+	"strings"
+	"time"
+
+	"github.com/MiXiaoAi/oinote/backend/internal/middleware"
+	"github.com/MiXiaoAi/oinote/backend/internal/models"
+	"github.com/gofiber/websocket/v2"
+	"gorm.io/gorm"
+)
+
+// 协同连接关闭码（4000-4999 为应用自定义范围，含义对应 HTTP 状态码）
+const (
+	CloseBadRequest   = 4400
+	CloseUnauthorized = 4401
+	CloseForbidden    = 4403
+	CloseNotFound     = 4404
+
+	// 共享角色变化，客户端应重新连接以获取新的角色
+	CloseRoleChanged = 4205
+)
+
+// closeWithCode 发送关闭帧后断开连接
+func closeWithCode(conn *websocket.Conn, code int, reason string) {
+	conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(time.Second))
+	conn.Close()
+}
+
+// connToken 获取连接携带的 JWT，浏览器无法为 WebSocket 设置请求头，因此同时支持 token 查询参数
+func connToken(conn *websocket.Conn) string {
+	if token := conn.Query("token"); token != "" {
+		return token
+	}
+	return strings.TrimPrefix(conn.Headers("Authorization"), "Bearer ")
+}
+
+// authenticateConn 校验连接的 JWT 并加载用户
+func authenticateConn(conn *websocket.Conn, db *gorm.DB) (*models.User, bool) {
+	tokenString := connToken(conn)
+	if tokenString == "" {
+		return nil, false
+	}
+
+	userID, _, err := middleware.ParseToken(tokenString)
+	if err != nil {
+		return nil, false
+	}
+
+	var user models.User
+	if err := db.First(&user, userID).Error; err != nil {
+		return nil, false
+	}
+	return &user, true
+}
+
+// canEditNote 判断用户是否可以参与笔记的协同编辑
+// 个人笔记只有作者可以编辑，频道笔记需要是频道的正式成员，共享为编辑者的用户也可以编辑
+func canEditNote(db *gorm.DB, note *models.Note, userID uint) bool {
+	if note.ChannelID == nil {
+		if note.OwnerID == userID {
+			return true
+		}
+	} else if models.IsChannelMember(db, *note.ChannelID, userID) {
+		return true
+	}
+	return models.NoteShareRole(db, note.ID, userID) == models.ShareRoleEditor
+}
+
+// collabRole 用户在笔记协同编辑中的角色，无权访问时为空
+// 公开笔记的访问者和共享为查看者、评论者的用户为只读观众
+func collabRole(db *gorm.DB, note *models.Note, userID uint) string {
+	if userID != 0 && canEditNote(db, note, userID) {
+		return RoleEditor
+	}
+	if note.IsPublic || models.NoteShareRole(db, note.ID, userID) != "" {
+		return RoleViewer
+	}
+	return ""
+}
+
+// RefreshUserAccess 笔记共享变化后重新检查用户已打开的协同连接
+// 失去访问权限的连接以 CloseForbidden 断开，角色变化的连接以 CloseRoleChanged 断开，由客户端重新连接
+func (s *YjsServer) RefreshUserAccess(noteID, userID uint) {
+	doc := s.getDocument(noteID)
+	if doc == nil {
+		return
+	}
+
+	var note models.Note
+	if err := s.DB.First(&note, noteID).Error; err != nil {
+		return
+	}
+	role := collabRole(s.DB, &note, userID)
+
+	doc.mu.RLock()
+	var stale []*YjsClient
+	for _, client := range doc.Clients {
+		if client.UserID == userID && client.Role != role && client.closeConn != nil {
+			stale = append(stale, client)
+		}
+	}
+	doc.mu.RUnlock()
+
+	for _, client := range stale {
+		log.Printf("协同连接权限变化: userID=%d, noteID=%d, role=%s -> %q", userID, noteID, client.Role, role)
+		if role == "" {
+			client.closeConn(CloseForbidden, "forbidden")
+		} else {
+			client.closeConn(CloseRoleChanged, "role changed")
+		}
+	}
+}
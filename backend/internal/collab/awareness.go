@@ -0,0 +1,363 @@
+package collab
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"sync/atomic"
+	"time"
+
+	y "github.com/skyterra/y-crdt"
+)
+
+const (
+	// 与 Yjs awareness 协议一致：超过30秒未续期的状态视为离线
+	awarenessTimeout = 30 * time.Second
+	// 过期检查间隔
+	awarenessCheckInterval = awarenessTimeout / 10
+	// JSON 协议客户端没有 Yjs clientID，从该值开始分配，避免与真实的 32 位 clientID 冲突
+	jsonAwarenessIDBase = 1 << 40
+)
+
+var nextJSONAwarenessID uint64 = jsonAwarenessIDBase
+
+// awarenessState 服务器保存的单个客户端感知状态
+type awarenessState struct {
+	AwarenessID uint64          // Yjs awareness clientID
+	ConnID      string          // 上报该状态的连接
+	Clock       uint64          // 状态时钟，只接受更新的时钟
+	State       json.RawMessage // 任意 JSON 对象（选区、颜色、状态等）
+	LastUpdated time.Time
+}
+
+// newJSONAwarenessID 为 JSON 协议连接分配 awareness clientID
+func newJSONAwarenessID() uint64 {
+	return atomic.AddUint64(&nextJSONAwarenessID, 1)
+}
+
+// encodeAwarenessUpdate 按 y-protocols 格式编码感知更新，State 为空表示移除
+func encodeAwarenessUpdate(states []*awarenessState) []byte {
+	encoder := new(bytes.Buffer)
+	y.WriteVarUint(encoder, uint64(len(states)))
+	for _, st := range states {
+		y.WriteVarUint(encoder, st.AwarenessID)
+		y.WriteVarUint(encoder, st.Clock)
+		if st.State == nil {
+			y.WriteString(encoder, "null")
+		} else {
+			y.WriteString(encoder, string(st.State))
+		}
+	}
+	return encoder.Bytes()
+}
+
+// awarenessJSONMessage 生成发给 JSON 协议客户端的感知消息
+func awarenessJSONMessage(st *awarenessState, client *YjsClient) []byte {
+	message := map[string]interface{}{
+		"type":        "awareness",
+		"clientId":    st.ConnID,
+		"awarenessId": st.AwarenessID,
+		"state":       st.State,
+		"timestamp":   st.LastUpdated.Unix(),
+	}
+
+	// 兼容旧客户端：附带用户信息和光标
+	if client != nil {
+		message["userId"] = client.UserID
+		message["username"] = client.Username
+		message["role"] = client.Role
+	}
+	var fields struct {
+		User struct {
+			Name string `json:"name"`
+		} `json:"user"`
+		Cursor json.RawMessage `json:"cursor"`
+	}
+	if json.Unmarshal(st.State, &fields) == nil {
+		message["nickname"] = fields.User.Name
+		message["cursor"] = fields.Cursor
+	}
+
+	data, err := json.Marshal(message)
+	if err != nil {
+		log.Printf("序列化感知消息失败: %v", err)
+		return nil
+	}
+	return data
+}
+
+// awarenessRemoveMessage 生成发给 JSON 协议客户端的感知移除消息
+func awarenessRemoveMessage(removed []*awarenessState) []byte {
+	ids := make([]uint64, 0, len(removed))
+	connIDs := make([]string, 0, len(removed))
+	for _, st := range removed {
+		ids = append(ids, st.AwarenessID)
+		connIDs = append(connIDs, st.ConnID)
+	}
+
+	data, err := json.Marshal(map[string]interface{}{
+		"type":         "awareness-remove",
+		"awarenessIds": ids,
+		"clientIds":    connIDs,
+	})
+	if err != nil {
+		log.Printf("序列化感知移除消息失败: %v", err)
+		return nil
+	}
+	return data
+}
+
+// broadcastAwarenessChanges 把变化的感知状态发送给除 senderConnID 外的所有客户端，调用方需持有 doc.mu
+func (s *YjsServer) broadcastAwarenessChanges(doc *YjsDocument, senderConnID string, changed []*awarenessState) {
+	if len(changed) == 0 {
+		return
+	}
+
+	binaryData := encodeAwarenessMessage(encodeAwarenessUpdate(changed))
+
+	var jsonMessages [][]byte
+	var removed []*awarenessState
+	for _, st := range changed {
+		if st.State == nil {
+			removed = append(removed, st)
+			continue
+		}
+		if data := awarenessJSONMessage(st, doc.Clients[st.ConnID]); data != nil {
+			jsonMessages = append(jsonMessages, data)
+		}
+	}
+	if len(removed) > 0 {
+		if data := awarenessRemoveMessage(removed); data != nil {
+			jsonMessages = append(jsonMessages, data)
+		}
+	}
+
+	for clientID, client := range doc.Clients {
+		if clientID == senderConnID {
+			continue
+		}
+		if client.Binary {
+			trySend(client, binaryData)
+			continue
+		}
+		for _, data := range jsonMessages {
+			trySend(client, data)
+		}
+	}
+}
+
+// connectionAwarenessState 按连接身份整理客户端上报的感知状态
+// user 字段由服务器按连接身份填写，观众只显示为旁观者，不显示光标
+func connectionAwarenessState(client *CollabClient, state map[string]interface{}) (json.RawMessage, error) {
+	displayName := client.Nickname
+	if displayName == "" {
+		displayName = client.Username
+	}
+	if state == nil {
+		state = make(map[string]interface{})
+	}
+	state["user"] = map[string]interface{}{
+		"id":       client.UserID,
+		"username": client.Username,
+		"name":     displayName,
+		"role":     client.Role,
+	}
+	if client.Role != RoleEditor {
+		delete(state, "cursor")
+		state["status"] = "watching"
+	}
+	return json.Marshal(state)
+}
+
+// ApplyBinaryAwareness 应用二进制协议客户端上报的感知更新
+// 状态必须是 JSON 对象，按连接身份改写后再保存和转发；属于其他连接的 clientID 不能被覆盖或移除
+func (s *YjsServer) ApplyBinaryAwareness(noteID uint, client *CollabClient, update []byte) {
+	doc := s.getDocument(noteID)
+	if doc == nil {
+		return
+	}
+
+	decoder := bytes.NewBuffer(update)
+	length := y.ReadVarUint(decoder)
+	now := time.Now()
+
+	doc.mu.Lock()
+	defer doc.mu.Unlock()
+
+	var changed []*awarenessState
+	for i := uint64(0); i < length; i++ {
+		awarenessID := y.ReadVarUint(decoder)
+		clock := y.ReadVarUint(decoder)
+		data, err := y.ReadString(decoder)
+		if err != nil {
+			log.Printf("解析感知更新失败: %v", err)
+			break
+		}
+
+		prev, exists := doc.awareness[awarenessID]
+		if exists && prev.ConnID != client.ID {
+			log.Printf("忽略其他连接的感知状态: %s, awarenessID=%d", client.ID, awarenessID)
+			continue
+		}
+
+		var state json.RawMessage
+		if data != "null" && data != "" {
+			var fields map[string]interface{}
+			if err := json.Unmarshal([]byte(data), &fields); err != nil || fields == nil {
+				log.Printf("忽略无效的感知状态: %s, awarenessID=%d", client.ID, awarenessID)
+				continue
+			}
+			if state, err = connectionAwarenessState(client, fields); err != nil {
+				log.Printf("序列化感知状态失败: %v", err)
+				continue
+			}
+		}
+
+		if exists && !(prev.Clock < clock || (prev.Clock == clock && state == nil && prev.State != nil)) {
+			continue
+		}
+
+		st := &awarenessState{
+			AwarenessID: awarenessID,
+			ConnID:      client.ID,
+			Clock:       clock,
+			State:       state,
+			LastUpdated: now,
+		}
+		if state == nil {
+			delete(doc.awareness, awarenessID)
+		} else {
+			doc.awareness[awarenessID] = st
+		}
+		changed = append(changed, st)
+	}
+
+	s.broadcastAwarenessChanges(doc, client.ID, changed)
+}
+
+// ApplyJSONAwareness 应用 JSON 协议客户端上报的感知状态
+func (s *YjsServer) ApplyJSONAwareness(noteID uint, client *CollabClient, state map[string]interface{}) {
+	doc := s.getDocument(noteID)
+	if doc == nil {
+		return
+	}
+
+	data, err := connectionAwarenessState(client, state)
+	if err != nil {
+		log.Printf("序列化感知状态失败: %v", err)
+		return
+	}
+
+	doc.mu.Lock()
+	defer doc.mu.Unlock()
+
+	st := &awarenessState{
+		AwarenessID: client.AwarenessID,
+		ConnID:      client.ID,
+		State:       data,
+		LastUpdated: time.Now(),
+	}
+	if prev, exists := doc.awareness[client.AwarenessID]; exists {
+		st.Clock = prev.Clock + 1
+	}
+	doc.awareness[client.AwarenessID] = st
+
+	s.broadcastAwarenessChanges(doc, client.ID, []*awarenessState{st})
+}
+
+// RemoveConnAwareness 移除连接上报的所有感知状态（连接断开时调用）
+func (s *YjsServer) RemoveConnAwareness(noteID uint, connID string) {
+	doc := s.getDocument(noteID)
+	if doc == nil {
+		return
+	}
+
+	doc.mu.Lock()
+	defer doc.mu.Unlock()
+
+	var removed []*awarenessState
+	for id, st := range doc.awareness {
+		if st.ConnID == connID {
+			delete(doc.awareness, id)
+			removed = append(removed, &awarenessState{
+				AwarenessID: id,
+				ConnID:      connID,
+				Clock:       st.Clock,
+			})
+		}
+	}
+
+	s.broadcastAwarenessChanges(doc, connID, removed)
+}
+
+// SendAwarenessSnapshot 向新加入的客户端发送当前所有感知状态
+func (s *YjsServer) SendAwarenessSnapshot(noteID uint, client *YjsClient) {
+	doc := s.getDocument(noteID)
+	if doc == nil {
+		return
+	}
+
+	doc.mu.RLock()
+	defer doc.mu.RUnlock()
+
+	if len(doc.awareness) == 0 {
+		return
+	}
+
+	states := make([]*awarenessState, 0, len(doc.awareness))
+	for _, st := range doc.awareness {
+		states = append(states, st)
+	}
+
+	if client.Binary {
+		trySend(client, encodeAwarenessMessage(encodeAwarenessUpdate(states)))
+		return
+	}
+	for _, st := range states {
+		if data := awarenessJSONMessage(st, doc.Clients[st.ConnID]); data != nil {
+			trySend(client, data)
+		}
+	}
+}
+
+// expireAwareness 定期移除超时未续期的感知状态，二进制和 JSON 协议客户端都需要定期续期
+func (s *YjsServer) expireAwareness() {
+	ticker := time.NewTicker(awarenessCheckInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		s.mu.RLock()
+		docs := make([]*YjsDocument, 0, len(s.documents))
+		for _, doc := range s.documents {
+			docs = append(docs, doc)
+		}
+		s.mu.RUnlock()
+
+		now := time.Now()
+		for _, doc := range docs {
+			doc.mu.Lock()
+			var removed []*awarenessState
+			for id, st := range doc.awareness {
+				if now.Sub(st.LastUpdated) >= awarenessTimeout {
+					delete(doc.awareness, id)
+					removed = append(removed, &awarenessState{
+						AwarenessID: id,
+						ConnID:      st.ConnID,
+						Clock:       st.Clock,
+					})
+				}
+			}
+			s.broadcastAwarenessChanges(doc, "", removed)
+			doc.mu.Unlock()
+		}
+	}
+}
+
+// trySend 非阻塞地向客户端发送消息
+func trySend(client *YjsClient, data []byte) {
+	select {
+	case client.Send <- data:
+	default:
+		log.Printf("发送消息到客户端失败: %s", client.ClientID)
+	}
+}
@@ -0,0 +1,79 @@
+package collab
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	y "github.com/skyterra/y-crdt"
+)
+
+// encodeTestAwareness 编码只包含一个状态的感知更新
+func encodeTestAwareness(awarenessID, clock uint64, state string) []byte {
+	encoder := new(bytes.Buffer)
+	y.WriteVarUint(encoder, 1)
+	y.WriteVarUint(encoder, awarenessID)
+	y.WriteVarUint(encoder, clock)
+	y.WriteString(encoder, state)
+	return encoder.Bytes()
+}
+
+func newAwarenessTestClient(server *YjsServer, id, role string) *CollabClient {
+	return &CollabClient{ID: id, UserID: 7, Username: "bob", Send: make(chan []byte, 16), NoteID: 1, Role: role, Binary: true, Server: server}
+}
+
+func TestApplyBinaryAwarenessSanitizesState(t *testing.T) {
+	server := &YjsServer{documents: make(map[uint]*YjsDocument)}
+	doc := server.GetOrCreateDocument(1, "")
+	viewer := newAwarenessTestClient(server, "viewer", RoleViewer)
+
+	server.ApplyBinaryAwareness(1, viewer, encodeTestAwareness(42, 1, `{"user":{"name":"admin","id":1},"cursor":{"anchor":1}}`))
+
+	st, ok := doc.awareness[42]
+	if !ok {
+		t.Fatal("state not stored")
+	}
+	var state struct {
+		User   map[string]interface{} `json:"user"`
+		Cursor json.RawMessage        `json:"cursor"`
+		Status string                 `json:"status"`
+	}
+	if err := json.Unmarshal(st.State, &state); err != nil {
+		t.Fatal(err)
+	}
+	if state.User["username"] != "bob" || state.User["id"] != float64(7) {
+		t.Fatalf("user not taken from connection: %v", state.User)
+	}
+	if state.Cursor != nil || state.Status != "watching" {
+		t.Fatalf("viewer state not stripped: %s", st.State)
+	}
+}
+
+func TestApplyBinaryAwarenessRejectsInvalidState(t *testing.T) {
+	server := &YjsServer{documents: make(map[uint]*YjsDocument)}
+	doc := server.GetOrCreateDocument(1, "")
+	editor := newAwarenessTestClient(server, "editor", RoleEditor)
+
+	for _, state := range []string{`{"user":`, `[1,2]`, `"text"`, `42`} {
+		server.ApplyBinaryAwareness(1, editor, encodeTestAwareness(42, 1, state))
+		if _, ok := doc.awareness[42]; ok {
+			t.Fatalf("invalid state %q stored", state)
+		}
+	}
+}
+
+func TestApplyBinaryAwarenessKeepsOtherConnectionState(t *testing.T) {
+	server := &YjsServer{documents: make(map[uint]*YjsDocument)}
+	doc := server.GetOrCreateDocument(1, "")
+	owner := newAwarenessTestClient(server, "owner", RoleEditor)
+	other := newAwarenessTestClient(server, "other", RoleEditor)
+
+	server.ApplyBinaryAwareness(1, owner, encodeTestAwareness(42, 1, `{}`))
+	server.ApplyBinaryAwareness(1, other, encodeTestAwareness(42, 5, `{"status":"away"}`))
+	server.ApplyBinaryAwareness(1, other, encodeTestAwareness(42, 6, `null`))
+
+	st, ok := doc.awareness[42]
+	if !ok || st.ConnID != "owner" || st.Clock != 1 {
+		t.Fatalf("state of another connection was changed: %+v", st)
+	}
+}
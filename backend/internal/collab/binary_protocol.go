@@ -0,0 +1,149 @@
+package collab
+
+import (
+	"bytes"
+	"encoding/binary"
+	"log"
+
+	y "github.com/skyterra/y-crdt"
+)
+
+// y-websocket 标准二进制协议的消息类型（lib0 编码）
+const (
+	messageSync           = 0
+	messageAwareness      = 1
+	messageAuth           = 2
+	messageQueryAwareness = 3
+)
+
+// messageAuth 子类型
+const (
+	authPermissionDenied = 0
+)
+
+// encodeSyncStep1 编码 sync-step1 消息（携带服务器状态向量）
+func encodeSyncStep1(stateVector []byte) []byte {
+	encoder := new(bytes.Buffer)
+	y.WriteVarUint(encoder, messageSync)
+	y.WriteVarUint(encoder, y.MessageYjsSyncStep1)
+	y.WriteVarUint8Array(encoder, stateVector)
+	return encoder.Bytes()
+}
+
+// encodeSyncStep2 编码 sync-step2 消息（携带差异更新）
+func encodeSyncStep2(update []byte) []byte {
+	encoder := new(bytes.Buffer)
+	y.WriteVarUint(encoder, messageSync)
+	y.WriteVarUint(encoder, y.MessageYjsSyncStep2)
+	y.WriteVarUint8Array(encoder, update)
+	return encoder.Bytes()
+}
+
+// encodeSyncUpdate 编码增量更新消息
+func encodeSyncUpdate(update []byte) []byte {
+	encoder := new(bytes.Buffer)
+	y.WriteVarUint(encoder, messageSync)
+	y.WriteVarUint(encoder, y.MessageYjsUpdate)
+	y.WriteVarUint8Array(encoder, update)
+	return encoder.Bytes()
+}
+
+// encodeAwarenessMessage 编码感知状态消息
+func encodeAwarenessMessage(update []byte) []byte {
+	encoder := new(bytes.Buffer)
+	y.WriteVarUint(encoder, messageAwareness)
+	y.WriteVarUint8Array(encoder, update)
+	return encoder.Bytes()
+}
+
+// encodePermissionDenied 编码权限拒绝消息
+func encodePermissionDenied(reason string) []byte {
+	encoder := new(bytes.Buffer)
+	y.WriteVarUint(encoder, messageAuth)
+	y.WriteVarUint(encoder, authPermissionDenied)
+	y.WriteString(encoder, reason)
+	return encoder.Bytes()
+}
+
+// readVarUint8Array 读取带长度前缀的字节数组
+// 长度来自客户端，超过剩余数据时视为无效消息，不能直接交给 y.ReadVarUint8Array 按该长度分配内存
+func readVarUint8Array(decoder *bytes.Buffer) ([]byte, bool) {
+	size, err := binary.ReadUvarint(decoder)
+	if err != nil || size > uint64(decoder.Len()) {
+		return nil, false
+	}
+	buf := make([]byte, size)
+	copy(buf, decoder.Next(int(size)))
+	return buf, true
+}
+
+// handleBinaryMessage 处理 y-websocket 二进制协议消息
+func (c *CollabClient) handleBinaryMessage(message []byte) {
+	decoder := bytes.NewBuffer(message)
+
+	switch y.ReadVarUint(decoder) {
+	case messageSync:
+		c.handleBinarySync(decoder)
+
+	case messageAwareness:
+		update, ok := readVarUint8Array(decoder)
+		if !ok {
+			log.Printf("解析感知消息失败: %s", c.ID)
+			return
+		}
+		c.Server.ApplyBinaryAwareness(c.NoteID, c, update)
+
+	case messageQueryAwareness:
+		c.Server.SendAwarenessSnapshot(c.NoteID, &YjsClient{ClientID: c.ID, Binary: true, Send: c.Send})
+
+	case messageAuth:
+		// 客户端不应发送认证消息
+
+	default:
+		log.Printf("未知的二进制消息类型: %s", c.ID)
+	}
+}
+
+// handleBinarySync 处理同步子协议
+func (c *CollabClient) handleBinarySync(decoder *bytes.Buffer) {
+	syncType := y.ReadVarUint(decoder)
+	payload, ok := readVarUint8Array(decoder)
+	if !ok {
+		log.Printf("解析同步消息失败: %s", c.ID)
+		return
+	}
+
+	switch syncType {
+	case y.MessageYjsSyncStep1:
+		// 客户端发送状态向量，回复差异更新
+		update := c.Server.HandleSyncStep1(c.NoteID, c.ID, payload)
+		if update != nil {
+			c.sendRaw(encodeSyncStep2(update))
+		}
+
+	case y.MessageYjsSyncStep2, y.MessageYjsUpdate:
+		// 客户端发送更新（sync-step2 中是服务器缺少的部分）
+		if c.Role != RoleEditor {
+			if syncType == y.MessageYjsUpdate {
+				c.sendRaw(encodePermissionDenied("read-only"))
+			}
+			return
+		}
+		if len(payload) == 0 {
+			return
+		}
+		c.Server.HandleUpdate(c.NoteID, c.ID, payload)
+
+	default:
+		log.Printf("未知的同步消息类型: %s", c.ID)
+	}
+}
+
+// sendRaw 向客户端发送已编码的消息
+func (c *CollabClient) sendRaw(data []byte) {
+	select {
+	case c.Send <- data:
+	default:
+		log.Printf("发送消息到客户端失败: %s", c.ID)
+	}
+}
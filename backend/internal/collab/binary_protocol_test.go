@@ -0,0 +1,71 @@
+package collab
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestReadVarUint8Array(t *testing.T) {
+	tests := []struct {
+		name  string
+		input []byte
+		want  []byte
+		ok    bool
+	}{
+		{"valid", []byte{3, 'a', 'b', 'c', 'd'}, []byte("abc"), true},
+		{"empty array", []byte{0}, []byte{}, true},
+		{"truncated", []byte{10, 1, 2, 3}, nil, false},
+		{"oversized", []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01}, nil, false},
+		{"missing length", []byte{}, nil, false},
+		{"unterminated length", []byte{0x80}, nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := readVarUint8Array(bytes.NewBuffer(tt.input))
+			if ok != tt.ok {
+				t.Fatalf("ok = %v, want %v", ok, tt.ok)
+			}
+			if ok && !bytes.Equal(got, tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandleBinaryMessageMalformedFrames(t *testing.T) {
+	oversized := []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01}
+	frames := map[string][]byte{
+		"empty":                     {},
+		"sync step1 truncated":      {messageSync, 0, 10, 1, 2, 3},
+		"sync update truncated":     {messageSync, 2, 10, 1, 2, 3},
+		"sync step1 oversized":      append([]byte{messageSync, 0}, oversized...),
+		"sync update oversized":     append([]byte{messageSync, 2}, oversized...),
+		"awareness truncated":       {messageAwareness, 5, 1},
+		"awareness oversized":       append([]byte{messageAwareness}, oversized...),
+		"awareness state truncated": {messageAwareness, 3, 1, 7, 0},
+	}
+
+	server := &YjsServer{documents: make(map[uint]*YjsDocument)}
+	server.GetOrCreateDocument(1, "hello")
+	client := &CollabClient{
+		ID:     "test",
+		Send:   make(chan []byte, 16),
+		NoteID: 1,
+		Role:   RoleEditor,
+		Binary: true,
+		Server: server,
+	}
+
+	for name, frame := range frames {
+		t.Run(name, func(t *testing.T) {
+			client.handleBinaryMessage(frame)
+			if got := server.GetDocumentContent(1); got != "hello" {
+				t.Fatalf("document changed to %q", got)
+			}
+			if len(server.documents[1].awareness) != 0 {
+				t.Fatalf("awareness state stored from malformed frame")
+			}
+		})
+	}
+}
@@ -5,8 +5,10 @@ import (
 	"fmt"
 	"log"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/MiXiaoAi/oinote/backend/internal/models"
 	"github.com/gofiber/websocket/v2"
 )
 
@@ -28,11 +30,15 @@ type CollabClient struct {
 	Username string
 	Nickname string
 	NoteID   uint
+	Role     string // editor, viewer
+	Binary   bool   // 是否使用 y-websocket 二进制协议
 	Server   *YjsServer
+
+	AwarenessID uint64 // JSON 协议连接的 awareness clientID
 }
 
 // NewCollabClient 创建新的协同编辑客户端
-func NewCollabClient(conn *websocket.Conn, userID uint, username string, nickname string, noteID uint, server *YjsServer) *CollabClient {
+func NewCollabClient(conn *websocket.Conn, userID uint, username string, nickname string, noteID uint, role string, server *YjsServer) *CollabClient {
 	clientID := fmt.Sprintf("%d-%d-%d", userID, noteID, time.Now().UnixNano())
 	
 	return &CollabClient{
@@ -43,14 +49,23 @@ func NewCollabClient(conn *websocket.Conn, userID uint, username string, nicknam
 		Username: username,
 		Nickname: nickname,
 		NoteID:   noteID,
+		Role:     role,
 		Server:   server,
+
+		AwarenessID: newJSONAwarenessID(),
 	}
 }
 
 // ReadPump 从 WebSocket 读取消息
 func (c *CollabClient) ReadPump() {
 	defer func() {
-		// 广播用户离开消息
+		// 畸形消息导致的 panic 只关闭当前连接，不影响其他连接
+		if r := recover(); r != nil {
+			log.Printf("处理协同消息失败，关闭连接: %s, err=%v", c.ID, r)
+		}
+
+		// 移除感知状态并广播用户离开消息
+		c.Server.RemoveConnAwareness(c.NoteID, c.ID)
 		c.broadcastUserLeft()
 		c.Server.RemoveClient(c.NoteID, c.ID)
 		c.Conn.Close()
@@ -71,7 +86,11 @@ func (c *CollabClient) ReadPump() {
 			break
 		}
 
-		c.handleMessage(message)
+		if c.Binary {
+			c.handleBinaryMessage(message)
+		} else {
+			c.handleMessage(message)
+		}
 	}
 }
 
@@ -93,7 +112,11 @@ func (c *CollabClient) WritePump() {
 			}
 
 			// 直接发送消息，不批量处理
-			if err := c.Conn.WriteMessage(websocket.TextMessage, message); err != nil {
+			messageType := websocket.TextMessage
+			if c.Binary {
+				messageType = websocket.BinaryMessage
+			}
+			if err := c.Conn.WriteMessage(messageType, message); err != nil {
 				return
 			}
 
@@ -196,6 +219,12 @@ func (c *CollabClient) handleSyncStep2(msg CollabMessage) {
 
 // handleUpdate 处理更新
 func (c *CollabClient) handleUpdate(msg CollabMessage) {
+	// 只读观众不允许修改文档
+	if c.Role != RoleEditor {
+		c.sendError("read-only", "只读模式下无法编辑")
+		return
+	}
+
 	var data struct {
 		Update []byte `json:"update"` // Go 会自动将 Base64 字符串解码为 []byte
 	}
@@ -210,61 +239,49 @@ func (c *CollabClient) handleUpdate(msg CollabMessage) {
 }
 
 // handleAwareness 处理感知状态
+// data.state 为任意客户端状态（选区、颜色、状态等），data.cursor 为旧版客户端的光标位置
 func (c *CollabClient) handleAwareness(msg CollabMessage) {
-	// 解析光标数据
 	var data struct {
+		State  map[string]interface{} `json:"state,omitempty"`
 		Cursor *struct {
 			From int `json:"from"`
 			To   int `json:"to"`
 		} `json:"cursor,omitempty"`
 	}
-	
+
 	if err := json.Unmarshal(msg.Data, &data); err != nil {
 		log.Printf("解析感知数据失败: %v", err)
 		return
 	}
 
-	// 广播感知状态到其他客户端
-	c.broadcastAwareness(data.Cursor)
-}
-
-// broadcastAwareness 广播感知状态
-func (c *CollabClient) broadcastAwareness(cursor interface{}) {
-	doc := c.Server.GetOrCreateDocument(c.NoteID, "")
-	
-	doc.mu.RLock()
-	defer doc.mu.RUnlock()
-
-	// 使用昵称，如果没有则使用用户名
-	displayName := c.Nickname
-	if displayName == "" {
-		displayName = c.Username
+	state := data.State
+	if state == nil {
+		state = make(map[string]interface{})
 	}
 
-	message := map[string]interface{}{
-		"type":      "awareness",
-		"clientId":  c.ID,
-		"userId":    c.UserID,
-		"username":  c.Username,
-		"nickname":  displayName,
-		"cursor":    cursor,
-		"timestamp": time.Now().Unix(),
+	// 观众的光标由 ApplyJSONAwareness 统一去掉
+	if data.Cursor != nil {
+		state["cursor"] = data.Cursor
 	}
 
-	data, err := json.Marshal(message)
+	c.Server.ApplyJSONAwareness(c.NoteID, c, state)
+}
+
+// sendError 向客户端发送错误消息
+func (c *CollabClient) sendError(code string, message string) {
+	data, err := json.Marshal(map[string]interface{}{
+		"type":    "error",
+		"code":    code,
+		"message": message,
+	})
 	if err != nil {
-		log.Printf("序列化感知消息失败: %v", err)
 		return
 	}
 
-	for clientID, client := range doc.Clients {
-		if clientID != c.ID {
-			select {
-			case client.Send <- data:
-			default:
-				log.Printf("发送感知状态到客户端失败: %s", clientID)
-			}
-		}
+	select {
+	case c.Send <- data:
+	default:
+		log.Printf("发送错误消息失败: %s", c.ID)
 	}
 }
 
@@ -289,7 +306,7 @@ func (c *CollabClient) broadcastUserLeft() {
 	}
 
 	for clientID, client := range doc.Clients {
-		if clientID != c.ID {
+		if clientID != c.ID && !client.Binary {
 			select {
 			case client.Send <- data:
 			default:
@@ -300,49 +317,91 @@ func (c *CollabClient) broadcastUserLeft() {
 }
 
 // HandleCollabWebSocket 处理协同编辑 WebSocket 连接
+// 默认使用 JSON 消息；通过 /ws/collab/:room 或 protocol=yjs 连接时使用 y-websocket 二进制协议
 func HandleCollabWebSocket(conn *websocket.Conn, server *YjsServer) {
-	// 从查询参数获取用户信息和笔记ID
-	userIDStr := conn.Query("userId")
-	username := conn.Query("username")
-	nickname := conn.Query("nickname")
-	noteIDStr := conn.Query("noteId")
+	// y-websocket 把房间名放在路径末尾，房间名为笔记ID（允许 note- 前缀）
+	room := conn.Params("room")
+	binary := room != "" || conn.Query("protocol") == "yjs"
 
-	if userIDStr == "" || noteIDStr == "" {
-		log.Printf("缺少必要参数: userId=%s, noteId=%s", userIDStr, noteIDStr)
-		conn.Close()
+	noteIDStr := conn.Query("noteId")
+	if room != "" {
+		noteIDStr = strings.TrimPrefix(room, "note-")
+	}
+	noteID, err := strconv.ParseUint(noteIDStr, 10, 64)
+	if err != nil || noteID == 0 {
+		log.Printf("无效的笔记ID: %s", noteIDStr)
+		closeWithCode(conn, CloseBadRequest, "invalid noteId")
 		return
 	}
 
-	userID, err := strconv.ParseUint(userIDStr, 10, 64)
-	if err != nil {
-		log.Printf("无效的用户ID: %s", userIDStr)
-		conn.Close()
-		return
+	// 先认证再加载笔记，笔记不存在与无权访问返回相同的关闭码，避免通过关闭码探测笔记ID
+	// 用户身份只从令牌中获取，不信任查询参数
+	user, authenticated := authenticateConn(conn, server.DB)
+	var userID uint
+	if authenticated {
+		userID = user.ID
 	}
 
-	noteID, err := strconv.ParseUint(noteIDStr, 10, 64)
-	if err != nil {
-		log.Printf("无效的笔记ID: %s", noteIDStr)
-		conn.Close()
+	// 有编辑权限的用户为编辑者，公开笔记的其他访问者和共享为查看者、评论者的用户为只读观众
+	var note models.Note
+	role := ""
+	if err := server.DB.First(&note, noteID).Error; err == nil {
+		role = collabRole(server.DB, &note, userID)
+	}
+	if role == "" {
+		log.Printf("协同编辑连接无权访问笔记: userID=%d, noteID=%d", userID, noteID)
+		if !authenticated {
+			closeWithCode(conn, CloseUnauthorized, "unauthorized")
+		} else {
+			closeWithCode(conn, CloseNotFound, "note not found")
+		}
 		return
 	}
 
+	if !authenticated {
+		user = &models.User{Username: "guest", Nickname: "访客"}
+	}
+
+	// 以笔记当前内容初始化协同文档（已有持久化数据时会被忽略）
+	server.GetOrCreateDocument(note.ID, note.Content)
+
 	// 创建客户端
-	client := NewCollabClient(conn, uint(userID), username, nickname, uint(noteID), server)
-	
+	client := NewCollabClient(conn, user.ID, user.Username, user.Nickname, note.ID, role, server)
+	client.Binary = binary
+
 	// 注册客户端到服务器
-	server.AddClient(uint(noteID), client.ID, uint(userID), username, client.Send)
-	
-	// 发送欢迎消息和活跃客户端列表
-	activeClients := server.GetActiveClients(uint(noteID))
-	welcomeMsg := map[string]interface{}{
-		"type":          "welcome",
-		"clientId":      client.ID,
-		"activeClients": activeClients,
+	yjsClient := &YjsClient{
+		ClientID: client.ID,
+		UserID:   user.ID,
+		Username: user.Username,
+		Role:     role,
+		Binary:   binary,
+		Send:     client.Send,
+		closeConn: func(code int, reason string) {
+			closeWithCode(conn, code, reason)
+		},
 	}
-	
-	welcomeData, _ := json.Marshal(welcomeMsg)
-	client.Send <- welcomeData
+	server.AddClient(note.ID, yjsClient)
+
+	if binary {
+		// 与 y-websocket 服务端一致：连接建立后立即发送 sync-step1
+		client.Send <- encodeSyncStep1(server.GetStateVector(note.ID))
+	} else {
+		// 发送欢迎消息和活跃客户端列表
+		activeClients := server.GetActiveClients(note.ID)
+		welcomeMsg := map[string]interface{}{
+			"type":          "welcome",
+			"clientId":      client.ID,
+			"role":          role,
+			"activeClients": activeClients,
+		}
+
+		welcomeData, _ := json.Marshal(welcomeMsg)
+		client.Send <- welcomeData
+	}
+
+	// 发送当前所有在线用户的感知状态，新加入者无需等待他人移动光标
+	server.SendAwarenessSnapshot(note.ID, yjsClient)
 
 	// 启动读写协程
 	go client.WritePump()
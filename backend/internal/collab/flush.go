@@ -0,0 +1,107 @@
+package collab
+
+import (
+	"log"
+	"time"
+
+	"github.com/MiXiaoAi/oinote/backend/internal/links"
+	"github.com/MiXiaoAi/oinote/backend/internal/models"
+	"github.com/MiXiaoAi/oinote/backend/internal/notify"
+	"github.com/MiXiaoAi/oinote/backend/internal/search"
+	"github.com/MiXiaoAi/oinote/backend/internal/websocket"
+)
+
+const (
+	// 最后一次编辑后等待多久写回笔记内容
+	flushDelay = 2 * time.Second
+	// 持续编辑时最长多久必须写回一次
+	flushMaxDelay = 10 * time.Second
+)
+
+// scheduleFlush 安排一次防抖写回，调用方需持有 doc.mu
+func (s *YjsServer) scheduleFlush(doc *YjsDocument) {
+	now := time.Now()
+	if !doc.dirty {
+		doc.dirty = true
+		doc.dirtySince = now
+	}
+
+	// 持续编辑超过最长等待时间时立即写回
+	if now.Sub(doc.dirtySince) >= flushMaxDelay {
+		if doc.flushTimer != nil {
+			doc.flushTimer.Stop()
+		}
+		go s.flushDocument(doc)
+		return
+	}
+
+	if doc.flushTimer != nil {
+		doc.flushTimer.Reset(flushDelay)
+		return
+	}
+	doc.flushTimer = time.AfterFunc(flushDelay, func() {
+		s.flushDocument(doc)
+	})
+}
+
+// flushDocument 将协同文档内容写回 Note.Content 并广播笔记更新
+func (s *YjsServer) flushDocument(doc *YjsDocument) {
+	if s.DB == nil {
+		return
+	}
+
+	// 同一文档的写回串行执行，FlushDocument 返回时之前开始的写回也已完成
+	doc.flushMu.Lock()
+	defer doc.flushMu.Unlock()
+
+	doc.mu.Lock()
+	if !doc.dirty {
+		doc.mu.Unlock()
+		return
+	}
+	content := doc.Doc.GetText("content").ToString()
+	editorID := doc.lastEditor
+	doc.dirty = false
+	doc.mu.Unlock()
+
+	var note models.Note
+	if err := s.DB.First(&note, doc.noteID).Error; err != nil {
+		return
+	}
+
+	if note.Content == content {
+		return
+	}
+	oldContent := note.Content
+
+	if err := s.DB.Model(&note).Update("content", content).Error; err != nil {
+		log.Printf("写回协同内容失败: noteID=%d, err=%v", doc.noteID, err)
+		return
+	}
+
+	// 重新加载完整的笔记信息，包括 Owner
+	s.DB.Preload("Owner").First(&note, note.ID)
+
+	RecordThrottledRevision(s.DB, &note, editorID, models.RevisionSourceCollab)
+	search.IndexNote(s.DB, &note)
+	notify.NoteMentions(s.DB, s.Hub, &note, oldContent, editorID)
+	links.Update(s.DB, &note)
+
+	// 广播笔记更新消息
+	if s.Hub != nil {
+		s.Hub.Publish(websocket.ToNote(note.ID), "note", "update", note)
+	}
+}
+
+// FlushDocument 立即写回指定笔记的协同内容
+func (s *YjsServer) FlushDocument(noteID uint) {
+	s.mu.RLock()
+	doc, exists := s.documents[noteID]
+	s.mu.RUnlock()
+
+	if !exists {
+		return
+	}
+
+	s.flushDocument(doc)
+}
@@ -0,0 +1,179 @@
+package collab
+
+import (
+	"log"
+	"time"
+
+	"github.com/MiXiaoAi/oinote/backend/internal/models"
+	y "github.com/skyterra/y-crdt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// 增量更新累计到该数量时立即压缩
+	compactThreshold = 200
+	// 定期压缩间隔
+	compactInterval = time.Minute
+)
+
+// loadDocument 从数据库恢复文档：先应用快照，再按顺序重放增量更新
+// 返回是否找到了持久化数据
+func (s *YjsServer) loadDocument(noteID uint, doc *YjsDocument) bool {
+	if s.DB == nil {
+		return false
+	}
+
+	found := false
+
+	var snapshot models.NoteYDoc
+	if err := s.DB.Where("note_id = ?", noteID).First(&snapshot).Error; err == nil {
+		if len(snapshot.State) > 0 {
+			y.ApplyUpdate(doc.Doc, snapshot.State, nil)
+		}
+		found = true
+	}
+
+	var updates []models.NoteYUpdate
+	s.DB.Where("note_id = ?", noteID).Order("id ASC").Find(&updates)
+	for _, u := range updates {
+		y.ApplyUpdate(doc.Doc, u.Update, nil)
+		doc.lastUpdateID = u.ID
+	}
+	doc.pendingUpdates = len(updates)
+
+	return found || len(updates) > 0
+}
+
+// storeUpdate 持久化一条增量更新，调用方需持有 doc.mu
+func (s *YjsServer) storeUpdate(doc *YjsDocument, update []byte) {
+	if s.DB == nil {
+		return
+	}
+
+	record := models.NoteYUpdate{
+		NoteID: doc.noteID,
+		Update: update,
+	}
+	if err := s.DB.Create(&record).Error; err != nil {
+		log.Printf("保存协同更新失败: noteID=%d, err=%v", doc.noteID, err)
+		return
+	}
+
+	doc.lastUpdateID = record.ID
+	doc.pendingUpdates++
+}
+
+// storeSnapshot 写入完整快照并删除已被快照覆盖的增量更新
+func (s *YjsServer) storeSnapshot(noteID uint, state []byte, uptoUpdateID uint) error {
+	return s.DB.Transaction(func(tx *gorm.DB) error {
+		snapshot := models.NoteYDoc{
+			NoteID: noteID,
+			State:  state,
+		}
+		if err := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "note_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"state", "updated_at"}),
+		}).Create(&snapshot).Error; err != nil {
+			return err
+		}
+
+		if uptoUpdateID > 0 {
+			if err := tx.Where("note_id = ? AND id <= ?", noteID, uptoUpdateID).
+				Delete(&models.NoteYUpdate{}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// compactDocument 将文档当前状态压缩为新快照
+func (s *YjsServer) compactDocument(doc *YjsDocument) {
+	if s.DB == nil {
+		return
+	}
+
+	doc.mu.Lock()
+	if doc.pendingUpdates == 0 {
+		doc.mu.Unlock()
+		return
+	}
+	state := y.EncodeStateAsUpdate(doc.Doc, nil)
+	uptoUpdateID := doc.lastUpdateID
+	pending := doc.pendingUpdates
+	doc.mu.Unlock()
+
+	if err := s.storeSnapshot(doc.noteID, state, uptoUpdateID); err != nil {
+		log.Printf("压缩协同文档失败: noteID=%d, err=%v", doc.noteID, err)
+		return
+	}
+
+	doc.mu.Lock()
+	doc.pendingUpdates -= pending
+	if doc.pendingUpdates < 0 {
+		doc.pendingUpdates = 0
+	}
+	doc.mu.Unlock()
+}
+
+// compactDocuments 定期压缩所有有待处理更新的文档
+func (s *YjsServer) compactDocuments() {
+	ticker := time.NewTicker(compactInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		s.mu.RLock()
+		docs := make([]*YjsDocument, 0, len(s.documents))
+		for _, doc := range s.documents {
+			docs = append(docs, doc)
+		}
+		s.mu.RUnlock()
+
+		for _, doc := range docs {
+			s.compactDocument(doc)
+		}
+	}
+}
+
+// CloseDocument 写回并压缩协同文档后将其移出内存，持久化的状态保留（用于笔记移入回收站）
+func (s *YjsServer) CloseDocument(noteID uint) {
+	s.mu.Lock()
+	doc, exists := s.documents[noteID]
+	delete(s.documents, noteID)
+	s.mu.Unlock()
+
+	if !exists {
+		return
+	}
+
+	s.flushDocument(doc)
+	s.compactDocument(doc)
+
+	doc.mu.Lock()
+	if doc.flushTimer != nil {
+		doc.flushTimer.Stop()
+	}
+	doc.mu.Unlock()
+}
+
+// DeleteDocument 删除文档的内存状态和持久化数据（笔记被删除时调用）
+func (s *YjsServer) DeleteDocument(noteID uint) {
+	s.mu.Lock()
+	if doc, exists := s.documents[noteID]; exists {
+		doc.mu.Lock()
+		doc.dirty = false
+		if doc.flushTimer != nil {
+			doc.flushTimer.Stop()
+		}
+		doc.mu.Unlock()
+		delete(s.documents, noteID)
+	}
+	s.mu.Unlock()
+
+	if s.DB == nil {
+		return
+	}
+	s.DB.Where("note_id = ?", noteID).Delete(&models.NoteYUpdate{})
+	s.DB.Where("note_id = ?", noteID).Delete(&models.NoteYDoc{})
+}
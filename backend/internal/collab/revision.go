@@ -0,0 +1,136 @@
+package collab
+
+import (
+	"log"
+	"time"
+	"unicode/utf16"
+
+	"github.com/MiXiaoAi/oinote/backend/internal/models"
+	y "github.com/skyterra/y-crdt"
+	"gorm.io/gorm"
+)
+
+// 自动保存和协同写回频繁发生，同一用户对同一笔记在该间隔内只保留一个同来源的版本
+const revisionInterval = 5 * time.Minute
+
+// RecordRevision 为笔记当前的标题和内容创建历史版本，内容与最新版本相同时跳过
+func RecordRevision(db *gorm.DB, note *models.Note, authorID uint, source string, restoredFrom *uint) error {
+	var latest models.NoteRevision
+	if err := db.Where("note_id = ?", note.ID).Order("id DESC").First(&latest).Error; err == nil {
+		if latest.Title == note.Title && latest.Content == note.Content {
+			return nil
+		}
+	}
+
+	revision := models.NoteRevision{
+		NoteID:       note.ID,
+		Title:        note.Title,
+		Content:      note.Content,
+		AuthorID:     authorID,
+		Source:       source,
+		RestoredFrom: restoredFrom,
+	}
+	if err := db.Create(&revision).Error; err != nil {
+		log.Printf("保存笔记版本失败: noteID=%d, err=%v", note.ID, err)
+		return err
+	}
+	return nil
+}
+
+// RecordThrottledRevision 按间隔创建版本，同一作者在间隔内已创建过同来源的版本时跳过
+func RecordThrottledRevision(db *gorm.DB, note *models.Note, authorID uint, source string) error {
+	var latest models.NoteRevision
+	err := db.Where("note_id = ? AND author_id = ? AND source = ?", note.ID, authorID, source).
+		Order("id DESC").First(&latest).Error
+	if err == nil && time.Since(latest.CreatedAt) < revisionInterval {
+		return nil
+	}
+
+	return RecordRevision(db, note, authorID, source, nil)
+}
+
+// ReplaceContent 用新内容替换协同文档中的文本（用于恢复历史版本）
+// 只替换首尾相同部分之间的文字，未改动的文字保持原样，评论锚点等相对位置不会失效
+// 替换产生的更新会持久化并广播给所有在线客户端，使各编辑器收敛到新内容
+func (s *YjsServer) ReplaceContent(noteID uint, content string) {
+	doc := s.GetOrCreateDocument(noteID, content)
+
+	doc.mu.Lock()
+	ytext := doc.Doc.GetText(contentTextName)
+	current := ytext.ToString()
+	if current == content {
+		doc.mu.Unlock()
+		return
+	}
+
+	// Y.Text 按 UTF-16 计数
+	oldUnits := utf16.Encode([]rune(current))
+	newUnits := utf16.Encode([]rune(content))
+	start, end := commonAffixes(oldUnits, newUnits)
+	deleteLength := len(oldUnits) - start - end
+	inserted := newUnits[start : len(newUnits)-end]
+
+	before := y.EncodeStateVector(doc.Doc, nil, y.NewUpdateEncoderV1())
+	doc.Doc.Transact(func(trans *y.Transaction) {
+		insertPlainText(trans, ytext, start, string(utf16.Decode(inserted)))
+		if deleteLength > 0 {
+			ytext.Delete(start+len(inserted), deleteLength)
+		}
+	}, nil)
+	update := y.EncodeStateAsUpdate(doc.Doc, before)
+
+	doc.LastUpdated = time.Now()
+	s.storeUpdate(doc, update)
+
+	// 笔记内容已由调用方保存，写回时内容相同会跳过；并发执行的写回覆盖了新内容时由这次写回纠正
+	s.scheduleFlush(doc)
+	doc.mu.Unlock()
+
+	s.broadcastUpdate(doc, "", update)
+}
+
+// insertPlainText 在 Y.Text 的 index 处插入不带格式的文字
+// y-crdt 的 YText.Insert 在插入位置右侧只有已删除的条目时会越界（例如文本被清空后），这里直接集成新的文字条目
+func insertPlainText(trans *y.Transaction, text *y.YText, index int, str string) {
+	if str == "" {
+		return
+	}
+
+	pos := y.FindPosition(trans, text, index)
+	content := y.NewContentString(str)
+	if marker := text.GetSearchMarker(); marker != nil {
+		y.UpdateMarkerChanges(marker, pos.Index, content.GetLength())
+	}
+
+	clientID := trans.Doc.ClientID
+	item := y.NewItem(y.GenID(clientID, y.GetState(trans.Doc.Store, clientID)),
+		pos.Left, y.GetItemLastID(pos.Left), pos.Right, y.GetItemID(pos.Right), text, "", content)
+	item.Integrate(trans, 0)
+}
+
+// commonAffixes 计算两段 UTF-16 文本相同前缀和相同后缀的长度，不会拆开代理对
+func commonAffixes(a, b []uint16) (prefix, suffix int) {
+	maxPrefix := len(a)
+	if len(b) < maxPrefix {
+		maxPrefix = len(b)
+	}
+	for prefix < maxPrefix && a[prefix] == b[prefix] {
+		prefix++
+	}
+	if prefix > 0 && isHighSurrogate(a[prefix-1]) {
+		prefix--
+	}
+
+	maxSuffix := maxPrefix - prefix
+	for suffix < maxSuffix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	if suffix > 0 && isLowSurrogate(a[len(a)-suffix]) {
+		suffix--
+	}
+	return prefix, suffix
+}
+
+func isHighSurrogate(u uint16) bool { return u >= 0xd800 && u < 0xdc00 }
+
+func isLowSurrogate(u uint16) bool { return u >= 0xdc00 && u < 0xe000 }
@@ -0,0 +1,71 @@
+package collab
+
+import (
+	"testing"
+	"unicode/utf16"
+
+	y "github.com/skyterra/y-crdt"
+)
+
+func TestCommonAffixes(t *testing.T) {
+	tests := []struct {
+		name           string
+		a, b           string
+		prefix, suffix int
+	}{
+		{"middle changed", "hello world", "hello there world", 6, 5},
+		{"appended", "abc", "abcdef", 3, 0},
+		{"all replaced", "abc", "xyz", 0, 0},
+		{"repeated text", "aaa", "aa", 2, 0},
+		{"surrogate prefix", "a😀b", "a😁b", 1, 1},
+		{"surrogate suffix", "x😀", "y😀", 0, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prefix, suffix := commonAffixes(utf16.Encode([]rune(tt.a)), utf16.Encode([]rune(tt.b)))
+			if prefix != tt.prefix || suffix != tt.suffix {
+				t.Fatalf("got (%d, %d), want (%d, %d)", prefix, suffix, tt.prefix, tt.suffix)
+			}
+		})
+	}
+}
+
+func TestReplaceContentKeepsAnchors(t *testing.T) {
+	server := &YjsServer{documents: make(map[uint]*YjsDocument)}
+	server.GetOrCreateDocument(1, "<p>keep this text</p>")
+	start, end, ok := server.CreateAnchor(1, "", 8, 12)
+	if !ok {
+		t.Fatal("anchor not created")
+	}
+
+	for _, content := range []string{"<p>keep this text, more</p>", "<p>new: keep this text, more</p>"} {
+		server.ReplaceContent(1, content)
+		from, to, ok := server.ResolveAnchor(1, start, end)
+		if !ok || utf16Slice(content, from, to) != "this" {
+			t.Fatalf("anchor resolved to %d-%d in %q, ok=%v", from, to, content, ok)
+		}
+	}
+
+	// 清空后再写入：右侧只有已删除的条目
+	for _, content := range []string{"", "<p>keep this</p>", "<p>keep this 😀</p>"} {
+		server.ReplaceContent(1, content)
+		if got := server.GetDocumentContent(1); got != content {
+			t.Fatalf("content = %q, want %q", got, content)
+		}
+	}
+	from, to, ok := server.ResolveAnchor(1, start, end)
+	if !ok || from != to {
+		t.Fatalf("deleted anchor resolved to %d-%d, ok=%v", from, to, ok)
+	}
+
+	replica := y.NewDoc("replica", false, nil, nil, false)
+	y.ApplyUpdate(replica, y.EncodeStateAsUpdate(server.documents[1].Doc, nil), nil)
+	if got := replica.GetText(contentTextName).ToString(); got != "<p>keep this 😀</p>" {
+		t.Fatalf("replica content = %q", got)
+	}
+}
+
+func utf16Slice(s string, from, to int) string {
+	return string(utf16.Decode(utf16.Encode([]rune(s))[from:to]))
+}
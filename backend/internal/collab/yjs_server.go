@@ -7,7 +7,9 @@ import (
 	"sync"
 	"time"
 
+	"github.com/MiXiaoAi/oinote/backend/internal/websocket"
 	y "github.com/skyterra/y-crdt"
+	"gorm.io/gorm"
 )
 
 // YjsDocument 表示一个协同编辑文档
@@ -16,18 +18,42 @@ type YjsDocument struct {
 	LastUpdated time.Time
 	Clients     map[string]*YjsClient
 	mu          sync.RWMutex
+
+	noteID         uint
+	lastUpdateID   uint // 最近一条已持久化增量更新的ID
+	pendingUpdates int  // 尚未压缩进快照的增量更新数量
+
+	dirty      bool      // 是否有尚未写回 Note.Content 的编辑
+	dirtySince time.Time // 第一次未写回编辑的时间
+	flushTimer *time.Timer
+	flushMu    sync.Mutex // 串行化写回
+	lastEditor uint       // 最近一次编辑的用户，用于记录协同版本作者
+
+	awareness map[uint64]*awarenessState // awareness clientID -> 感知状态
 }
 
+// 协同角色
+const (
+	RoleEditor = "editor" // 可以编辑
+	RoleViewer = "viewer" // 只读观众
+)
+
 // YjsClient 表示连接到文档的客户端
 type YjsClient struct {
 	ClientID string
 	UserID   uint
 	Username string
+	Role     string
+	Binary   bool // 使用 y-websocket 二进制协议
 	Send     chan []byte
+
+	closeConn func(code int, reason string) // 以关闭码断开连接，权限变化时使用
 }
 
 // YjsServer 管理所有协同编辑文档
 type YjsServer struct {
+	DB        *gorm.DB
+	Hub       *websocket.Hub
 	documents map[uint]*YjsDocument // noteID -> YjsDocument
 	mu        sync.RWMutex
 }
@@ -55,14 +81,18 @@ type AwarenessState struct {
 }
 
 // NewYjsServer 创建新的 Yjs 服务器
-func NewYjsServer() *YjsServer {
+func NewYjsServer(db *gorm.DB, hub *websocket.Hub) *YjsServer {
 	server := &YjsServer{
+		DB:        db,
+		Hub:       hub,
 		documents: make(map[uint]*YjsDocument),
 	}
-	
-	// 启动清理任务
+
+	// 启动清理和压缩任务
 	go server.cleanupInactiveDocuments()
-	
+	go server.compactDocuments()
+	go server.expireAwareness()
+
 	return server
 }
 
@@ -78,41 +108,51 @@ func (s *YjsServer) GetOrCreateDocument(noteID uint, initialContent string) *Yjs
 
 	// 创建新文档
 	ydoc := y.NewDoc(fmt.Sprintf("%d", noteID), false, nil, nil, false)
-	
-	// 如果有初始内容，设置到文档中
-	if initialContent != "" {
-		ydoc.Transact(func(trans *y.Transaction) {
-			ytext := ydoc.GetText("content")
-			ytext.Insert(0, initialContent, nil)
-		}, nil)
-	}
 
 	doc := &YjsDocument{
 		Doc:         ydoc,
 		LastUpdated: time.Now(),
 		Clients:     make(map[string]*YjsClient),
+		noteID:      noteID,
+		awareness:   make(map[uint64]*awarenessState),
+	}
+
+	// 优先从数据库恢复，没有持久化数据时才使用初始内容
+	if !s.loadDocument(noteID, doc) && initialContent != "" {
+		ydoc.Transact(func(trans *y.Transaction) {
+			ytext := ydoc.GetText("content")
+			ytext.Insert(0, initialContent, nil)
+		}, nil)
+
+		// 立即保存初始快照，保证重启后各客户端看到的是同一份文档结构
+		if s.DB != nil {
+			if err := s.storeSnapshot(noteID, y.EncodeStateAsUpdate(ydoc, nil), 0); err != nil {
+				log.Printf("保存初始协同快照失败: noteID=%d, err=%v", noteID, err)
+			}
+		}
 	}
 
 	s.documents[noteID] = doc
-	
+
 	return doc
 }
 
+// getDocument 获取已加载的文档，不存在时返回 nil
+func (s *YjsServer) getDocument(noteID uint) *YjsDocument {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.documents[noteID]
+}
+
 // AddClient 添加客户端到文档
-func (s *YjsServer) AddClient(noteID uint, clientID string, userID uint, username string, send chan []byte) {
+func (s *YjsServer) AddClient(noteID uint, client *YjsClient) {
 	doc := s.GetOrCreateDocument(noteID, "")
-	
+
 	doc.mu.Lock()
 	defer doc.mu.Unlock()
 
-	client := &YjsClient{
-		ClientID: clientID,
-		UserID:   userID,
-		Username: username,
-		Send:     send,
-	}
-
-	doc.Clients[clientID] = client
+	doc.Clients[client.ClientID] = client
 }
 
 // RemoveClient 从文档移除客户端
@@ -141,6 +181,9 @@ func (s *YjsServer) HandleSyncStep1(noteID uint, clientID string, stateVector []
 		return nil
 	}
 
+	doc.mu.RLock()
+	defer doc.mu.RUnlock()
+
 	// 编码当前文档状态作为更新
 	var update []byte
 	if len(stateVector) > 0 {
@@ -165,12 +208,27 @@ func (s *YjsServer) HandleUpdate(noteID uint, clientID string, update []byte) {
 		return
 	}
 
-	// 直接应用更新
-	y.ApplyUpdate(doc.Doc, update, nil)
-	doc.LastUpdated = time.Now()
+	// 应用更新并持久化；无效的更新可能使 y-crdt panic，用 defer 释放锁，避免文档被一直锁住
+	needCompact := func() bool {
+		doc.mu.Lock()
+		defer doc.mu.Unlock()
+
+		y.ApplyUpdate(doc.Doc, update, nil)
+		doc.LastUpdated = time.Now()
+		if client, ok := doc.Clients[clientID]; ok {
+			doc.lastEditor = client.UserID
+		}
+		s.storeUpdate(doc, update)
+		s.scheduleFlush(doc)
+		return doc.pendingUpdates >= compactThreshold
+	}()
 
 	// 广播更新到其他客户端
 	s.broadcastUpdate(doc, clientID, update)
+
+	if needCompact {
+		go s.compactDocument(doc)
+	}
 }
 
 func min(a, b int) int {
@@ -195,11 +253,16 @@ func (s *YjsServer) broadcastUpdate(doc *YjsDocument, senderClientID string, upd
 		log.Printf("序列化更新消息失败: %v", err)
 		return
 	}
+	binaryData := encodeSyncUpdate(update)
 
 	for clientID, client := range doc.Clients {
 		if clientID != senderClientID {
+			payload := data
+			if client.Binary {
+				payload = binaryData
+			}
 			select {
-			case client.Send <- data:
+			case client.Send <- payload:
 			default:
 				log.Printf("发送更新到客户端失败: %s", clientID)
 			}
@@ -217,6 +280,9 @@ func (s *YjsServer) GetDocumentContent(noteID uint) string {
 		return ""
 	}
 
+	doc.mu.RLock()
+	defer doc.mu.RUnlock()
+
 	ytext := doc.Doc.GetText("content")
 	return ytext.ToString()
 }
@@ -231,6 +297,9 @@ func (s *YjsServer) GetStateVector(noteID uint) []byte {
 		return nil
 	}
 
+	doc.mu.RLock()
+	defer doc.mu.RUnlock()
+
 	stateVector := y.GetStateVector(doc.Doc.Store)
 	return y.EncodeStateVector(doc.Doc, stateVector, y.NewUpdateEncoderV1())
 }
@@ -243,7 +312,8 @@ func (s *YjsServer) cleanupInactiveDocuments() {
 	for range ticker.C {
 		s.mu.Lock()
 		now := time.Now()
-		
+
+		var evicted []*YjsDocument
 		for noteID, doc := range s.documents {
 			doc.mu.RLock()
 			clientCount := len(doc.Clients)
@@ -253,11 +323,18 @@ func (s *YjsServer) cleanupInactiveDocuments() {
 			// 如果文档没有客户端且超过30分钟未更新，则清理
 			if clientCount == 0 && now.Sub(lastUpdated) > 30*time.Minute {
 				delete(s.documents, noteID)
+				evicted = append(evicted, doc)
 				log.Printf("清理不活跃文档: noteID=%d", noteID)
 			}
 		}
-		
+
 		s.mu.Unlock()
+
+		// 清理前写回内容并压缩，下次打开时只需加载快照
+		for _, doc := range evicted {
+			s.flushDocument(doc)
+			s.compactDocument(doc)
+		}
 	}
 }
 
@@ -280,6 +357,7 @@ func (s *YjsServer) GetActiveClients(noteID uint) []map[string]interface{} {
 			"clientId": client.ClientID,
 			"userId":   client.UserID,
 			"username": client.Username,
+			"role":     client.Role,
 		})
 	}
 
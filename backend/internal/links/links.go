@@ -0,0 +1,116 @@
+package links
+
+import (
+	"log"
+	"regexp"
+	"strings"
+
+	"github.com/MiXiaoAi/oinote/backend/internal/models"
+	"github.com/MiXiaoAi/oinote/backend/internal/search"
+	"gorm.io/gorm"
+)
+
+// linkRegex 匹配 [[标题]]，也支持 [[标题|显示文字]] 和 [[标题#段落]]
+var linkRegex = regexp.MustCompile(`\[\[([^\[\]\n]+)\]\]`)
+
+// Parse 提取内容中引用的笔记标题（去重，保持出现顺序），内容可以是 HTML
+func Parse(content string) []string {
+	var titles []string
+	seen := make(map[string]bool)
+	for _, match := range linkRegex.FindAllStringSubmatch(search.StripHTML(content), -1) {
+		title := match[1]
+		// 显示文字和段落位置不属于标题
+		if index := strings.IndexAny(title, "|#"); index >= 0 {
+			title = title[:index]
+		}
+		title = strings.TrimSpace(title)
+		if title == "" || seen[title] {
+			continue
+		}
+		seen[title] = true
+		titles = append(titles, title)
+	}
+	return titles
+}
+
+// inScope 限定为与笔记同一范围的笔记：频道笔记为同一频道，个人笔记为同一所有者的个人笔记
+func inScope(note *models.Note) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if note.ChannelID != nil {
+			return db.Where("channel_id = ?", *note.ChannelID)
+		}
+		return db.Where("channel_id IS NULL AND owner_id = ?", note.OwnerID)
+	}
+}
+
+// resolve 在笔记所在范围内查找指定标题的笔记，有多篇同名笔记时取ID最小的一篇
+func resolve(db *gorm.DB, note *models.Note, title string) *uint {
+	var target models.Note
+	if err := db.Scopes(inScope(note)).Select("id").Where("title = ?", title).Order("id").First(&target).Error; err != nil {
+		return nil
+	}
+	return &target.ID
+}
+
+// Update 重新提取笔记内容中的引用，替换笔记原有的引用记录
+func Update(db *gorm.DB, note *models.Note) {
+	titles := Parse(note.Content)
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("source_id = ?", note.ID).Delete(&models.NoteLink{}).Error; err != nil {
+			return err
+		}
+		for _, title := range titles {
+			link := models.NoteLink{
+				SourceID:    note.ID,
+				TargetID:    resolve(tx, note, title),
+				TargetTitle: title,
+			}
+			if err := tx.Create(&link).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("更新笔记引用失败: noteID=%d, err=%v", note.ID, err)
+	}
+}
+
+// Relink 重新解析笔记所在范围内引用了这些标题的记录
+// 笔记创建、改名、删除或恢复后调用，使引用指向当前同名的笔记
+func Relink(db *gorm.DB, note *models.Note, titles ...string) {
+	// 回收站中的笔记的引用也一并更新，恢复后保持正确
+	sources := db.Unscoped().Model(&models.Note{}).Scopes(inScope(note)).Select("id")
+	for _, title := range titles {
+		if title == "" {
+			continue
+		}
+		if err := db.Model(&models.NoteLink{}).
+			Where("target_title = ? AND source_id IN (?)", title, sources).
+			Update("target_id", resolve(db, note, title)).Error; err != nil {
+			log.Printf("更新笔记引用失败: title=%s, err=%v", title, err)
+		}
+	}
+}
+
+// Remove 删除笔记的引用记录，并断开其他笔记对它的引用（笔记被彻底删除时调用，避免ID被复用时关联到新笔记）
+func Remove(db *gorm.DB, noteID uint) {
+	db.Where("source_id = ?", noteID).Delete(&models.NoteLink{})
+	db.Model(&models.NoteLink{}).Where("target_id = ?", noteID).Update("target_id", nil)
+}
+
+// Rebuild 从全部笔记内容（包括回收站中的笔记）重建引用记录
+func Rebuild(db *gorm.DB) {
+	var notes []models.Note
+	db.Unscoped().Find(&notes)
+	count := 0
+	for i := range notes {
+		if len(Parse(notes[i].Content)) > 0 {
+			Update(db, &notes[i])
+			count++
+		}
+	}
+	if count > 0 {
+		log.Printf("已重建笔记引用: 笔记 %d 篇", count)
+	}
+}
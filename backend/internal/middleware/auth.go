@@ -1,12 +1,13 @@
 package middleware
 
 import (
-	"github.com/MiXiaoAi/oinote/backend/internal/models"
-	"github.com/MiXiaoAi/oinote/backend/config"
+	"errors"
 	"strings"
 
-	"github.com/golang-jwt/jwt/v5"
+	"github.com/MiXiaoAi/oinote/backend/config"
+	"github.com/MiXiaoAi/oinote/backend/internal/models"
 	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
 )
 
 var JwtSecret = []byte("oinote_secret_key_123456")
@@ -17,18 +18,34 @@ func AuthRequired(c *fiber.Ctx) error {
 		return c.Status(401).JSON(fiber.Map{"error": "未授权"})
 	}
 
-	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		return JwtSecret, nil
-	})
+	userID, username, err := ParseToken(strings.TrimPrefix(authHeader, "Bearer "))
+	if err != nil {
+		return c.Status(401).JSON(fiber.Map{"error": "无效的令牌"})
+	}
+
+	c.Locals("userId", userID)
+	c.Locals("username", username)
 
-	if err != nil || !token.Valid {
+	return c.Next()
+}
+
+// StreamAuth 事件流认证中间件，浏览器的 EventSource 无法设置请求头，因此同时支持 token 查询参数
+func StreamAuth(c *fiber.Ctx) error {
+	token := strings.TrimPrefix(c.Get("Authorization"), "Bearer ")
+	if token == "" {
+		token = c.Query("token")
+	}
+	if token == "" {
+		return c.Status(401).JSON(fiber.Map{"error": "未授权"})
+	}
+
+	userID, username, err := ParseUserToken(token)
+	if err != nil {
 		return c.Status(401).JSON(fiber.Map{"error": "无效的令牌"})
 	}
 
-	claims := token.Claims.(jwt.MapClaims)
-	c.Locals("userId", uint(claims["user_id"].(float64)))
-	c.Locals("username", claims["username"].(string))
+	c.Locals("userId", userID)
+	c.Locals("username", username)
 
 	return c.Next()
 }
@@ -40,20 +57,54 @@ func OptionalAuth(c *fiber.Ctx) error {
 		return c.Next()
 	}
 
-	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	userID, username, err := ParseToken(strings.TrimPrefix(authHeader, "Bearer "))
+	if err != nil {
+		return c.Next() // token无效时继续，不返回错误
+	}
+
+	c.Locals("userId", userID)
+	c.Locals("username", username)
+
+	return c.Next()
+}
+
+// ParseToken 校验 JWT 并返回其中的用户ID和用户名
+func ParseToken(tokenString string) (uint, string, error) {
 	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
 		return JwtSecret, nil
 	})
+	if err != nil {
+		return 0, "", err
+	}
+	if !token.Valid {
+		return 0, "", errors.New("无效的令牌")
+	}
 
-	if err != nil || !token.Valid {
-		return c.Next() // token无效时继续，不返回错误
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return 0, "", errors.New("无效的令牌")
 	}
+	userID, ok := claims["user_id"].(float64)
+	if !ok {
+		return 0, "", errors.New("令牌缺少用户ID")
+	}
+	username, _ := claims["username"].(string)
+
+	return uint(userID), username, nil
+}
 
-	claims := token.Claims.(jwt.MapClaims)
-	c.Locals("userId", uint(claims["user_id"].(float64)))
-	c.Locals("username", claims["username"].(string))
+// ParseUserToken 校验 JWT 并确认其中的用户仍然存在，用于实时事件等长连接的认证
+func ParseUserToken(tokenString string) (uint, string, error) {
+	userID, username, err := ParseToken(tokenString)
+	if err != nil {
+		return 0, "", err
+	}
 
-	return c.Next()
+	var count int64
+	if err := config.DB.Model(&models.User{}).Where("id = ?", userID).Count(&count).Error; err != nil || count == 0 {
+		return 0, "", errors.New("用户不存在")
+	}
+	return userID, username, nil
 }
 
 // AdminRequired 管理员权限检查中间件
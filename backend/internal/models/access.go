@@ -0,0 +1,35 @@
+package models
+
+import "gorm.io/gorm"
+
+// NoteShareRole 笔记共享给用户的角色，没有共享时为空
+func NoteShareRole(db *gorm.DB, noteID, userID uint) string {
+	if userID == 0 {
+		return ""
+	}
+	var share NoteShare
+	if err := db.Select("role").Where("note_id = ? AND user_id = ?", noteID, userID).First(&share).Error; err != nil {
+		return ""
+	}
+	return share.Role
+}
+
+// IsChannelMember 判断用户是否是频道的正式成员
+func IsChannelMember(db *gorm.DB, channelID, userID uint) bool {
+	var count int64
+	db.Model(&ChannelMember{}).
+		Where("channel_id = ? AND user_id = ? AND status = ?", channelID, userID, MemberStatusActive).
+		Count(&count)
+	return count > 0
+}
+
+// CanViewNote 公开笔记所有人可见，否则只有作者、所属频道的成员和被共享的用户可见
+func CanViewNote(db *gorm.DB, note *Note, userID uint) bool {
+	if note.IsPublic || note.OwnerID == userID {
+		return true
+	}
+	if note.ChannelID != nil && IsChannelMember(db, *note.ChannelID, userID) {
+		return true
+	}
+	return NoteShareRole(db, note.ID, userID) != ""
+}
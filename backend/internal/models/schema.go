@@ -2,6 +2,8 @@ package models
 
 import (
 	"time"
+
+	"gorm.io/gorm"
 )
 
 // 成员状态常量
@@ -11,6 +13,12 @@ const (
 	MemberStatusPending = "pending" // 申请加入，等待管理员批准
 )
 
+// 频道类型
+const (
+	ChannelKindChannel = "channel" // 普通频道
+	ChannelKindDirect  = "direct"  // 私信会话，不在频道列表中显示，没有邀请和审批流程
+)
+
 // 角色常量
 const (
 	RoleOwner  = "owner"
@@ -32,9 +40,10 @@ type User struct {
 }
 
 type Channel struct {
-	ID        uint      `gorm:"primaryKey" json:"id"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID        uint           `gorm:"primaryKey" json:"id"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"deleted_at"` // 非空表示在回收站中
 
 	Name        string `gorm:"not null" json:"name"`
 	Description string `json:"description"`
@@ -43,6 +52,9 @@ type Channel struct {
 	ThemeColor  string `gorm:"default:'#87CEEB'" json:"theme_color"` // 天蓝色
 	Tags        string `json:"tags"`                                 // 逗号分隔
 	MemberCount int    `gorm:"-" json:"member_count"`                // 成员数（不从数据库加载）
+	Kind        string `gorm:"size:16;default:'channel';index" json:"kind"` // channel, direct
+	// 私信会话参与者ID升序以逗号连接，同一组参与者只有一个会话；普通频道为空
+	DirectKey *string `gorm:"uniqueIndex" json:"-"`
 
 	// 关联
 	Owner   User            `gorm:"foreignKey:OwnerID" json:"owner,omitempty"`
@@ -64,22 +76,116 @@ type ChannelMember struct {
 }
 
 type Note struct {
-	ID        uint      `gorm:"primaryKey" json:"id"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID        uint           `gorm:"primaryKey" json:"id"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"deleted_at"` // 非空表示在回收站中
 
 	Title        string `json:"title"`
 	Content      string `gorm:"type:text" json:"content"`
 	ChannelID    *uint  `gorm:"index" json:"channel_id"`   // null 为个人笔记
+	FolderID     *uint  `gorm:"index" json:"folder_id"`    // null 为不在文件夹中
 	OwnerID      uint   `gorm:"index" json:"owner_id"`
 	IsPublic     bool   `gorm:"default:false" json:"is_public"`
 	Tags         string `json:"tags"`           // 逗号分隔
 	LineSpacing  float64 `gorm:"default:1.5" json:"line_spacing"` // 行间距
 
+	// 表情回应统计，查询时填充
+	Reactions []ReactionSummary `gorm:"-" json:"reactions,omitempty"`
+	// 笔记共享给当前用户时的角色，查询时填充
+	ShareRole string `gorm:"-" json:"share_role,omitempty"`
+
 	// 关联
 	Owner User `gorm:"foreignKey:OwnerID" json:"owner"`
 }
 
+// Folder 笔记文件夹，可以嵌套；个人文件夹只属于创建者，频道文件夹由频道成员共同管理
+type Folder struct {
+	ID        uint           `gorm:"primaryKey" json:"id"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"deleted_at"` // 非空表示在回收站中
+
+	Name      string `gorm:"not null" json:"name"`
+	ParentID  *uint  `gorm:"index" json:"parent_id"`  // null 为顶层文件夹
+	ChannelID *uint  `gorm:"index" json:"channel_id"` // null 为个人文件夹
+	OwnerID   uint   `gorm:"index" json:"owner_id"`   // 创建者
+	Position  int    `gorm:"default:0" json:"position"` // 在同级文件夹中的顺序
+}
+
+// 笔记共享角色
+const (
+	ShareRoleViewer    = "viewer"    // 只能查看
+	ShareRoleCommenter = "commenter" // 可以查看和评论
+	ShareRoleEditor    = "editor"    // 可以编辑内容
+)
+
+// NoteShare 笔记共享给指定用户，每个用户在一篇笔记上只有一个角色
+type NoteShare struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	NoteID    uint   `gorm:"uniqueIndex:idx_note_share_note_user" json:"note_id"`
+	UserID    uint   `gorm:"uniqueIndex:idx_note_share_note_user;index" json:"user_id"`
+	Role      string `gorm:"size:16" json:"role"` // viewer, commenter, editor
+	GrantedBy uint   `json:"granted_by"`
+
+	User User `gorm:"foreignKey:UserID" json:"user"`
+}
+
+// ShareLink 笔记的公开分享链接，通过随机令牌访问，可以设置有效期、密码和访问次数
+type ShareLink struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	NoteID       uint       `gorm:"index" json:"note_id"`
+	CreatorID    uint       `json:"creator_id"`
+	Token        string     `gorm:"size:64;uniqueIndex;not null" json:"token"`
+	PasswordHash string     `json:"-"`                  // 为空表示不需要密码
+	HasPassword  bool       `gorm:"-" json:"has_password"`
+	ExpiresAt    *time.Time `json:"expires_at"`         // null 为永久有效
+	MaxViews     int        `gorm:"default:0" json:"max_views"` // 0 为不限次数
+	ViewCount    int        `gorm:"default:0" json:"view_count"`
+	RevokedAt    *time.Time `json:"revoked_at"`         // 非空表示已撤销
+}
+
+// NoteComment 笔记的行内评论，话题锚定在协同文档内容的一段文字上，回复只有一层
+type NoteComment struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	NoteID   uint   `gorm:"index" json:"note_id"`
+	UserID   uint   `gorm:"index" json:"user_id"`
+	ParentID *uint  `gorm:"index" json:"parent_id"` // 回复所属的评论，为空表示评论话题
+	Content  string `gorm:"type:text" json:"content"`
+	// 话题锚定文字的起止位置，编码后的 Yjs 相对位置，并发编辑后仍指向同一段文字；回复和整篇笔记的评论没有锚点
+	AnchorStart []byte     `json:"anchor_start"`
+	AnchorEnd   []byte     `json:"anchor_end"`
+	Quote       string     `json:"quote"` // 创建评论时锚定的文字
+	ResolvedAt  *time.Time `json:"resolved_at"`
+	ResolvedBy  *uint      `json:"resolved_by"`
+
+	// 锚点在当前内容中的位置（UTF-16 索引），查询时填充；锚定的文字被删除时 from 等于 to
+	From    *int          `gorm:"-" json:"from"`
+	To      *int          `gorm:"-" json:"to"`
+	Replies []NoteComment `gorm:"-" json:"replies,omitempty"`
+
+	User User `gorm:"foreignKey:UserID" json:"user"`
+}
+
+// NoteLink 笔记内容中的 [[标题]] 引用，按标题在同一个人或频道范围内解析到目标笔记
+type NoteLink struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+
+	SourceID    uint   `gorm:"index" json:"source_id"`    // 包含引用的笔记
+	TargetID    *uint  `gorm:"index" json:"target_id"`    // 引用的笔记，没有同名笔记时为 null
+	TargetTitle string `gorm:"index" json:"target_title"` // 引用中写的标题
+}
+
 type Attachment struct {
 	ID        uint      `gorm:"primaryKey" json:"id"`
 	CreatedAt time.Time `json:"created_at"`
@@ -95,19 +201,140 @@ type Attachment struct {
 }
 
 type ChannelMessage struct {
-	ID            uint      `gorm:"primaryKey" json:"id"`
-	CreatedAt     time.Time `json:"created_at"`
-	UpdatedAt     time.Time `json:"updated_at"`
-	ChannelID     uint      `gorm:"index" json:"channel_id"`
-	UserID        uint      `gorm:"index" json:"user_id"`
-	Content       string    `gorm:"type:text" json:"content"`
-	AttachmentID  *uint     `json:"attachment_id"`
-	IsHighlighted bool      `gorm:"default:false" json:"is_highlighted"`
+	ID            uint           `gorm:"primaryKey" json:"id"`
+	CreatedAt     time.Time      `json:"created_at"`
+	UpdatedAt     time.Time      `json:"updated_at"`
+	DeletedAt     gorm.DeletedAt `gorm:"index" json:"deleted_at"` // 非空表示在回收站中
+	ChannelID     uint           `gorm:"index" json:"channel_id"`
+	UserID        uint           `gorm:"index" json:"user_id"`
+	Content       string         `gorm:"type:text" json:"content"`
+	AttachmentID  *uint          `json:"attachment_id"`
+	IsHighlighted bool           `gorm:"default:false" json:"is_highlighted"`
+	// 话题回复所属的首条消息，为空表示频道中的普通消息；回复只有一层
+	ParentID *uint `gorm:"index" json:"parent_id"`
+	// 首条消息的回复数和最后回复时间，随回复的发送、删除和恢复更新
+	ReplyCount  int        `gorm:"default:0" json:"reply_count"`
+	LastReplyAt *time.Time `json:"last_reply_at"`
+	// 最后编辑时间，非空表示消息被编辑过
+	EditedAt *time.Time `json:"edited_at"`
+	// 表情回应统计，查询时填充
+	Reactions []ReactionSummary `gorm:"-" json:"reactions,omitempty"`
 
 	User       User       `gorm:"foreignKey:UserID" json:"user"`
 	Attachment Attachment `gorm:"foreignKey:AttachmentID" json:"attachment"`
 }
 
+// ChannelMessageEdit 频道消息每次编辑前的内容
+type ChannelMessageEdit struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	CreatedAt time.Time `json:"created_at"` // 编辑时间
+
+	MessageID uint   `gorm:"index" json:"message_id"`
+	ChannelID uint   `gorm:"index" json:"channel_id"`
+	Content   string `gorm:"type:text" json:"content"` // 编辑前的内容
+	EditorID  uint   `json:"editor_id"`
+
+	Editor User `gorm:"foreignKey:EditorID" json:"editor"`
+}
+
+// ChannelReadState 用户在频道中已读到的位置
+type ChannelReadState struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	UserID            uint `gorm:"uniqueIndex:idx_read_state_user_channel" json:"user_id"`
+	ChannelID         uint `gorm:"uniqueIndex:idx_read_state_user_channel;index" json:"channel_id"`
+	LastReadMessageID uint `json:"last_read_message_id"` // 该消息及之前的消息视为已读
+}
+
+// 通知类型
+const (
+	NotificationMention = "mention" // 在频道消息或笔记中被 @ 提及
+)
+
+// Notification 用户收件箱中的通知
+type Notification struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+
+	UserID    uint       `gorm:"index" json:"user_id"` // 接收通知的用户
+	ActorID   uint       `json:"actor_id"`             // 触发通知的用户
+	Type      string     `gorm:"size:32" json:"type"`
+	ChannelID *uint      `gorm:"index" json:"channel_id"`
+	MessageID *uint      `gorm:"index" json:"message_id"`
+	NoteID    *uint      `gorm:"index" json:"note_id"`
+	Excerpt   string     `json:"excerpt"` // 提及位置附近的文字
+	ReadAt    *time.Time `json:"read_at"`
+
+	Actor User `gorm:"foreignKey:ActorID" json:"actor"`
+}
+
+// 表情回应的对象类型
+const (
+	ReactionTargetMessage = "message"
+	ReactionTargetNote    = "note"
+)
+
+// Reaction 用户对频道消息或笔记的表情回应，同一用户对同一对象的同一表情只记录一次
+type Reaction struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+
+	TargetType string `gorm:"size:16;uniqueIndex:idx_reaction_target_user_emoji" json:"target_type"` // message, note
+	TargetID   uint   `gorm:"uniqueIndex:idx_reaction_target_user_emoji" json:"target_id"`
+	UserID     uint   `gorm:"uniqueIndex:idx_reaction_target_user_emoji;index" json:"user_id"`
+	Emoji      string `gorm:"size:64;uniqueIndex:idx_reaction_target_user_emoji" json:"emoji"`
+}
+
+// ReactionSummary 某个表情的回应统计
+type ReactionSummary struct {
+	Emoji   string `json:"emoji"`
+	Count   int    `json:"count"`
+	UserIDs []uint `json:"user_ids"`
+	Reacted bool   `json:"reacted"` // 当前用户是否回应过
+}
+
+// 笔记版本来源
+const (
+	RevisionSourceSave    = "save"    // 通过接口保存
+	RevisionSourceCollab  = "collab"  // 协同编辑写回
+	RevisionSourceRestore = "restore" // 从历史版本恢复
+)
+
+// NoteRevision 笔记的历史版本快照
+type NoteRevision struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+
+	NoteID       uint   `gorm:"index" json:"note_id"`
+	Title        string `json:"title"`
+	Content      string `gorm:"type:text" json:"content,omitempty"`
+	AuthorID     uint   `json:"author_id"`                    // 产生该版本的用户，0 表示未知
+	Source       string `gorm:"default:'save'" json:"source"` // save, collab, restore
+	RestoredFrom *uint  `json:"restored_from,omitempty"`      // 恢复自哪个版本
+
+	Author User `gorm:"foreignKey:AuthorID" json:"author"`
+}
+
+// NoteYDoc 协同文档的压缩快照（Yjs 编码的完整状态）
+type NoteYDoc struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	NoteID uint   `gorm:"uniqueIndex" json:"note_id"`
+	State  []byte `json:"-"`
+}
+
+// NoteYUpdate 协同文档尚未压缩进快照的增量更新，按 ID 顺序重放
+type NoteYUpdate struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+
+	NoteID uint   `gorm:"index" json:"note_id"`
+	Update []byte `json:"-"`
+}
+
 type AIConfig struct {
 	ID        uint      `gorm:"primaryKey" json:"id"`
 	CreatedAt time.Time `json:"created_at"`
@@ -118,3 +345,16 @@ type AIConfig struct {
 	Model      string `json:"model"`       // Model name (e.g., gpt-4, gpt-3.5-turbo)
 	UpdatedBy  uint   `json:"updated_by"`  // 最后更新的管理员ID
 }
+
+// 回收站默认保留天数
+const DefaultTrashRetentionDays = 30
+
+// TrashConfig 回收站配置，只有一条记录，ID 为 1
+type TrashConfig struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	RetentionDays int  `gorm:"default:30" json:"retention_days"` // 删除后保留多少天再彻底清除
+	UpdatedBy     uint `json:"updated_by"`                        // 最后更新的管理员ID
+}
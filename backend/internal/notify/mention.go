@@ -0,0 +1,156 @@
+package notify
+
+import (
+	"log"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/MiXiaoAi/oinote/backend/internal/models"
+	"github.com/MiXiaoAi/oinote/backend/internal/search"
+	"github.com/MiXiaoAi/oinote/backend/internal/websocket"
+	"gorm.io/gorm"
+)
+
+// 通知摘要在提及位置前后保留的字数
+const (
+	excerptBefore = 30
+	excerptAfter  = 70
+)
+
+// mentionRegex 匹配 @用户名，@ 前不能紧跟字母数字，以排除邮箱地址
+var mentionRegex = regexp.MustCompile(`(?:^|[^\p{L}\p{N}_.])@([\p{L}\p{N}_.\-]+)`)
+
+// ParseMentions 提取内容中提及的用户名（去重，保持出现顺序），内容可以是 HTML
+func ParseMentions(content string) []string {
+	var usernames []string
+	seen := make(map[string]bool)
+	for _, match := range mentionRegex.FindAllStringSubmatch(search.StripHTML(content), -1) {
+		// 句末的标点不属于用户名
+		username := strings.TrimRight(match[1], ".-")
+		if username == "" || seen[username] {
+			continue
+		}
+		seen[username] = true
+		usernames = append(usernames, username)
+	}
+	return usernames
+}
+
+// newMentions 返回新内容中提及、旧内容中没有提及的用户，编辑时只通知新增的提及
+func newMentions(db *gorm.DB, content, oldContent string, actorID uint) []models.User {
+	old := make(map[string]bool)
+	for _, username := range ParseMentions(oldContent) {
+		old[username] = true
+	}
+	var usernames []string
+	for _, username := range ParseMentions(content) {
+		if !old[username] {
+			usernames = append(usernames, username)
+		}
+	}
+	if len(usernames) == 0 {
+		return nil
+	}
+
+	var users []models.User
+	db.Where("username IN ? AND id <> ?", usernames, actorID).Find(&users)
+	return users
+}
+
+// MessageMentions 为频道消息中新提及的用户创建通知，oldContent 为编辑前的内容，新消息传空
+// 私有频道只通知频道成员
+func MessageMentions(db *gorm.DB, hub *websocket.Hub, message *models.ChannelMessage, oldContent string) {
+	users := newMentions(db, message.Content, oldContent, message.UserID)
+	if len(users) == 0 {
+		return
+	}
+
+	var channel models.Channel
+	if err := db.First(&channel, message.ChannelID).Error; err != nil {
+		return
+	}
+
+	var notifications []models.Notification
+	for _, user := range users {
+		if !channel.IsPublic && !models.IsChannelMember(db, channel.ID, user.ID) {
+			continue
+		}
+		channelID, messageID := message.ChannelID, message.ID
+		notifications = append(notifications, models.Notification{
+			UserID:    user.ID,
+			ActorID:   message.UserID,
+			Type:      models.NotificationMention,
+			ChannelID: &channelID,
+			MessageID: &messageID,
+			Excerpt:   excerpt(message.Content, user.Username),
+		})
+	}
+	create(db, hub, notifications)
+}
+
+// NoteMentions 为笔记中新提及的用户创建通知，只通知能访问该笔记的用户
+func NoteMentions(db *gorm.DB, hub *websocket.Hub, note *models.Note, oldContent string, actorID uint) {
+	users := newMentions(db, note.Content, oldContent, actorID)
+	if len(users) == 0 {
+		return
+	}
+
+	var notifications []models.Notification
+	for _, user := range users {
+		if !models.CanViewNote(db, note, user.ID) {
+			continue
+		}
+		noteID := note.ID
+		notifications = append(notifications, models.Notification{
+			UserID:    user.ID,
+			ActorID:   actorID,
+			Type:      models.NotificationMention,
+			ChannelID: note.ChannelID,
+			NoteID:    &noteID,
+			Excerpt:   excerpt(note.Content, user.Username),
+		})
+	}
+	create(db, hub, notifications)
+}
+
+// create 保存通知并实时推送给接收者的所有设备
+func create(db *gorm.DB, hub *websocket.Hub, notifications []models.Notification) {
+	for i := range notifications {
+		notification := &notifications[i]
+		if err := db.Create(notification).Error; err != nil {
+			log.Printf("创建通知失败: userID=%d, err=%v", notification.UserID, err)
+			continue
+		}
+		db.Preload("Actor").First(notification, notification.ID)
+		if hub != nil {
+			hub.Publish(websocket.ToUsers(notification.UserID), "notification", "create", notification)
+		}
+	}
+}
+
+// excerpt 截取提及位置附近的文字作为通知摘要
+func excerpt(content, username string) string {
+	plain := search.StripHTML(content)
+	text := []rune(plain)
+	start := 0
+	if index := strings.Index(plain, "@"+username); index >= 0 {
+		start = utf8.RuneCountInString(plain[:index]) - excerptBefore
+	}
+	if start < 0 {
+		start = 0
+	}
+	end := start + excerptBefore + excerptAfter
+	if end > len(text) {
+		end = len(text)
+	}
+
+	result := string(text[start:end])
+	if start > 0 {
+		result = "…" + result
+	}
+	if end < len(text) {
+		result += "…"
+	}
+	return result
+}
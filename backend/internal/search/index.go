@@ -0,0 +1,119 @@
+package search
+
+import (
+	"html"
+	"log"
+	"regexp"
+	"strings"
+
+	"github.com/MiXiaoAi/oinote/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+// 索引条目类型
+const (
+	KindNote    = "note"
+	KindMessage = "message"
+)
+
+var (
+	// 脚本和样式内容不参与索引
+	scriptStyleRegex = regexp.MustCompile(`(?is)<(script|style)[^>]*>.*?</(script|style)>`)
+	blockTagRegex    = regexp.MustCompile(`(?i)</?(p|div|br|hr|li|ul|ol|h[1-6]|blockquote|pre|table|tr|td|th)\b[^>]*>`)
+	htmlTagRegex     = regexp.MustCompile(`<[^>]*>`)
+	whitespaceRegex  = regexp.MustCompile(`\s+`)
+)
+
+// StripHTML 去除 HTML 标签并还原实体，得到用于索引的纯文本
+func StripHTML(content string) string {
+	content = scriptStyleRegex.ReplaceAllString(content, " ")
+	// 块级标签替换为空格，避免相邻段落的文字粘连；行内标签直接去掉
+	content = blockTagRegex.ReplaceAllString(content, " ")
+	content = htmlTagRegex.ReplaceAllString(content, "")
+	content = html.UnescapeString(content)
+	return strings.TrimSpace(whitespaceRegex.ReplaceAllString(content, " "))
+}
+
+// Setup 创建全文索引表，索引为空时从现有数据重建
+// 使用 trigram 分词器，中文等无空格分隔的文字也能按子串检索
+func Setup(db *gorm.DB) error {
+	if err := db.Exec("CREATE VIRTUAL TABLE IF NOT EXISTS search_index USING fts5(kind UNINDEXED, ref_id UNINDEXED, title, body, tags, tokenize='trigram')").Error; err != nil {
+		return err
+	}
+
+	var count int64
+	if err := db.Raw("SELECT COUNT(*) FROM search_index").Scan(&count).Error; err != nil {
+		return err
+	}
+	if count == 0 {
+		return Rebuild(db)
+	}
+	return nil
+}
+
+// Rebuild 重建全部笔记和频道消息的索引（包括回收站中的内容）
+func Rebuild(db *gorm.DB) error {
+	if err := db.Exec("DELETE FROM search_index").Error; err != nil {
+		return err
+	}
+
+	var notes []models.Note
+	db.Unscoped().Find(&notes)
+	for i := range notes {
+		IndexNote(db, &notes[i])
+	}
+
+	var messages []models.ChannelMessage
+	db.Unscoped().Find(&messages)
+	for i := range messages {
+		IndexMessage(db, &messages[i])
+	}
+
+	if len(notes)+len(messages) > 0 {
+		log.Printf("已重建搜索索引: 笔记 %d 篇, 消息 %d 条", len(notes), len(messages))
+	}
+	return nil
+}
+
+// 笔记和消息共用一张索引表，rowid 按类型交错分配
+func noteRowID(noteID uint) uint {
+	return noteID * 2
+}
+
+func messageRowID(messageID uint) uint {
+	return messageID*2 + 1
+}
+
+// upsert 写入或替换一条索引记录
+func upsert(db *gorm.DB, rowID uint, kind string, refID uint, title, body, tags string) {
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("DELETE FROM search_index WHERE rowid = ?", rowID).Error; err != nil {
+			return err
+		}
+		return tx.Exec("INSERT INTO search_index (rowid, kind, ref_id, title, body, tags) VALUES (?, ?, ?, ?, ?, ?)",
+			rowID, kind, refID, title, body, tags).Error
+	})
+	if err != nil {
+		log.Printf("更新搜索索引失败: %s %d, err=%v", kind, refID, err)
+	}
+}
+
+// IndexNote 更新笔记的索引
+func IndexNote(db *gorm.DB, note *models.Note) {
+	upsert(db, noteRowID(note.ID), KindNote, note.ID, note.Title, StripHTML(note.Content), note.Tags)
+}
+
+// IndexMessage 更新频道消息的索引
+func IndexMessage(db *gorm.DB, message *models.ChannelMessage) {
+	upsert(db, messageRowID(message.ID), KindMessage, message.ID, "", StripHTML(message.Content), "")
+}
+
+// RemoveNote 删除笔记的索引（笔记被彻底删除时调用）
+func RemoveNote(db *gorm.DB, noteID uint) {
+	db.Exec("DELETE FROM search_index WHERE rowid = ?", noteRowID(noteID))
+}
+
+// RemoveMessage 删除频道消息的索引（消息被彻底删除时调用）
+func RemoveMessage(db *gorm.DB, messageID uint) {
+	db.Exec("DELETE FROM search_index WHERE rowid = ?", messageRowID(messageID))
+}
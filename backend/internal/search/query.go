@@ -0,0 +1,360 @@
+package search
+
+import (
+	"html"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/MiXiaoAi/oinote/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+const (
+	// trigram 分词器只能用 MATCH 检索至少3个字符的词，更短的词退化为 LIKE
+	minMatchRunes = 3
+	// 摘要在命中位置前后保留的字符数
+	snippetBefore = 30
+	snippetAfter  = 80
+)
+
+// Query 搜索条件
+type Query struct {
+	Text       string
+	Type       string // note, message，为空时都搜索
+	UserID     uint   // 当前用户，0 表示访客
+	ChannelIDs []uint // 当前用户加入的频道
+
+	ChannelID *uint
+	Tag       string
+	OwnerID   uint
+	From      string // 创建日期下限（含），格式 2006-01-02
+	To        string // 创建日期上限（不含）
+
+	Limit  int
+	Offset int
+}
+
+// Result 单条搜索结果
+type Result struct {
+	Type           string                 `json:"type"`
+	ID             uint                   `json:"id"`
+	Rank           float64                `json:"rank"`
+	TitleHighlight string                 `json:"title_highlight"`
+	Snippet        string                 `json:"snippet"`
+	Note           *models.Note           `json:"note,omitempty"`
+	Message        *models.ChannelMessage `json:"message,omitempty"`
+}
+
+// hit 索引查询的原始结果
+type hit struct {
+	Kind  string
+	RefID uint
+	Title string
+	Body  string
+	Rank  float64
+}
+
+// textCondition 根据搜索词生成全文检索条件，返回条件、参数、是否使用 MATCH
+func textCondition(terms []string) (string, []interface{}, bool) {
+	var conds []string
+	var args []interface{}
+	var matchTerms []string
+
+	for _, term := range terms {
+		if utf8.RuneCountInString(term) >= minMatchRunes {
+			// 每个词作为短语检索，避免用户输入被解析为 FTS5 语法
+			matchTerms = append(matchTerms, `"`+strings.ReplaceAll(term, `"`, `""`)+`"`)
+			continue
+		}
+		like := "%" + escapeLike(term) + "%"
+		conds = append(conds, `(search_index.title LIKE ? ESCAPE '\' OR search_index.body LIKE ? ESCAPE '\' OR search_index.tags LIKE ? ESCAPE '\')`)
+		args = append(args, like, like, like)
+	}
+
+	if len(matchTerms) > 0 {
+		conds = append([]string{"search_index MATCH ?"}, conds...)
+		args = append([]interface{}{strings.Join(matchTerms, " ")}, args...)
+	}
+
+	return strings.Join(conds, " AND "), args, len(matchTerms) > 0
+}
+
+// escapeLike 转义 LIKE 通配符
+func escapeLike(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, "%", `\%`)
+	return strings.ReplaceAll(s, "_", `\_`)
+}
+
+// noteSQL 生成笔记部分的查询
+func (q *Query) noteSQL(textCond string, textArgs []interface{}, rankExpr string) (string, []interface{}) {
+	sql := "SELECT search_index.kind AS kind, search_index.ref_id AS ref_id, search_index.title AS title, search_index.body AS body, " +
+		rankExpr + " AS rank, notes.created_at AS created_at " +
+		"FROM search_index JOIN notes ON notes.id = search_index.ref_id " +
+		"WHERE search_index.kind = 'note' AND notes.deleted_at IS NULL AND " + textCond
+	args := append([]interface{}{}, textArgs...)
+
+	// 访客只能搜索公开笔记，登录用户还能搜索自己的笔记、所在频道的笔记和共享给自己的笔记
+	if q.UserID == 0 {
+		sql += " AND notes.is_public = ?"
+		args = append(args, true)
+	} else {
+		sql += " AND (notes.is_public = ? OR notes.owner_id = ? OR notes.channel_id IN ? OR notes.id IN (SELECT note_id FROM note_shares WHERE user_id = ?))"
+		args = append(args, true, q.UserID, q.ChannelIDs, q.UserID)
+	}
+
+	if q.ChannelID != nil {
+		sql += " AND notes.channel_id = ?"
+		args = append(args, *q.ChannelID)
+	}
+	if q.Tag != "" {
+		sql += " AND (',' || REPLACE(notes.tags, ' ', '') || ',') LIKE ? ESCAPE '\\'"
+		args = append(args, "%,"+escapeLike(strings.ReplaceAll(q.Tag, " ", ""))+",%")
+	}
+	if q.OwnerID != 0 {
+		sql += " AND notes.owner_id = ?"
+		args = append(args, q.OwnerID)
+	}
+	if q.From != "" {
+		sql += " AND notes.created_at >= ?"
+		args = append(args, q.From)
+	}
+	if q.To != "" {
+		sql += " AND notes.created_at < ?"
+		args = append(args, q.To)
+	}
+
+	return sql, args
+}
+
+// messageSQL 生成频道消息部分的查询
+func (q *Query) messageSQL(textCond string, textArgs []interface{}, rankExpr string) (string, []interface{}) {
+	sql := "SELECT search_index.kind AS kind, search_index.ref_id AS ref_id, search_index.title AS title, search_index.body AS body, " +
+		rankExpr + " AS rank, channel_messages.created_at AS created_at " +
+		"FROM search_index JOIN channel_messages ON channel_messages.id = search_index.ref_id " +
+		"JOIN channels ON channels.id = channel_messages.channel_id " +
+		"WHERE search_index.kind = 'message' AND channel_messages.deleted_at IS NULL AND channels.deleted_at IS NULL AND " + textCond
+	args := append([]interface{}{}, textArgs...)
+
+	// 公开频道的消息所有人可见，私有频道需要是成员
+	if q.UserID == 0 {
+		sql += " AND channels.is_public = ?"
+		args = append(args, true)
+	} else {
+		sql += " AND (channels.is_public = ? OR channel_messages.channel_id IN ?)"
+		args = append(args, true, q.ChannelIDs)
+	}
+
+	if q.ChannelID != nil {
+		sql += " AND channel_messages.channel_id = ?"
+		args = append(args, *q.ChannelID)
+	}
+	if q.OwnerID != 0 {
+		sql += " AND channel_messages.user_id = ?"
+		args = append(args, q.OwnerID)
+	}
+	if q.From != "" {
+		sql += " AND channel_messages.created_at >= ?"
+		args = append(args, q.From)
+	}
+	if q.To != "" {
+		sql += " AND channel_messages.created_at < ?"
+		args = append(args, q.To)
+	}
+
+	return sql, args
+}
+
+// Search 执行全文搜索，返回当前页结果和总数
+func Search(db *gorm.DB, q Query) ([]Result, int64, error) {
+	terms := strings.Fields(q.Text)
+	if len(terms) == 0 {
+		return []Result{}, 0, nil
+	}
+
+	textCond, textArgs, useMatch := textCondition(terms)
+	// bm25 越小越相关，标题权重最高，其次是标签
+	rankExpr := "0"
+	if useMatch {
+		rankExpr = "bm25(search_index, 0.0, 0.0, 10.0, 1.0, 5.0)"
+	}
+
+	var parts []string
+	var args []interface{}
+	if q.Type == "" || q.Type == KindNote {
+		sql, partArgs := q.noteSQL(textCond, textArgs, rankExpr)
+		parts = append(parts, sql)
+		args = append(args, partArgs...)
+	}
+	// 消息没有标签，按标签筛选时只搜索笔记
+	if (q.Type == "" || q.Type == KindMessage) && q.Tag == "" {
+		sql, partArgs := q.messageSQL(textCond, textArgs, rankExpr)
+		parts = append(parts, sql)
+		args = append(args, partArgs...)
+	}
+	if len(parts) == 0 {
+		return []Result{}, 0, nil
+	}
+	union := strings.Join(parts, " UNION ALL ")
+
+	var total int64
+	if err := db.Raw("SELECT COUNT(*) FROM ("+union+")", args...).Scan(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var hits []hit
+	pageArgs := append(args, q.Limit, q.Offset)
+	if err := db.Raw("SELECT kind, ref_id, title, body, rank FROM ("+union+") ORDER BY rank, created_at DESC LIMIT ? OFFSET ?", pageArgs...).
+		Scan(&hits).Error; err != nil {
+		return nil, 0, err
+	}
+
+	return loadResults(db, hits, terms), total, nil
+}
+
+// loadResults 加载命中的笔记和消息，生成高亮摘要
+func loadResults(db *gorm.DB, hits []hit, terms []string) []Result {
+	var noteIDs, messageIDs []uint
+	for _, h := range hits {
+		if h.Kind == KindNote {
+			noteIDs = append(noteIDs, h.RefID)
+		} else {
+			messageIDs = append(messageIDs, h.RefID)
+		}
+	}
+
+	notes := make(map[uint]*models.Note)
+	if len(noteIDs) > 0 {
+		var list []models.Note
+		db.Preload("Owner").Where("id IN ?", noteIDs).Find(&list)
+		for i := range list {
+			notes[list[i].ID] = &list[i]
+		}
+	}
+
+	messages := make(map[uint]*models.ChannelMessage)
+	if len(messageIDs) > 0 {
+		var list []models.ChannelMessage
+		db.Preload("User").Preload("Attachment").Where("id IN ?", messageIDs).Find(&list)
+		for i := range list {
+			messages[list[i].ID] = &list[i]
+		}
+	}
+
+	results := make([]Result, 0, len(hits))
+	for _, h := range hits {
+		result := Result{
+			Type:           h.Kind,
+			ID:             h.RefID,
+			Rank:           h.Rank,
+			TitleHighlight: highlight(h.Title, terms),
+			Snippet:        makeSnippet(h.Body, terms),
+		}
+		if h.Kind == KindNote {
+			if result.Note = notes[h.RefID]; result.Note == nil {
+				continue
+			}
+		} else {
+			if result.Message = messages[h.RefID]; result.Message == nil {
+				continue
+			}
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// lowerRunes 逐字符转小写，保持与原文的下标对应
+func lowerRunes(s string) []rune {
+	runes := []rune(s)
+	for i, r := range runes {
+		runes[i] = unicode.ToLower(r)
+	}
+	return runes
+}
+
+// matchAt 返回在 pos 处命中的最长搜索词长度，未命中返回0
+func matchAt(text []rune, pos int, terms [][]rune) int {
+	best := 0
+	for _, term := range terms {
+		if len(term) <= best || pos+len(term) > len(text) {
+			continue
+		}
+		matched := true
+		for i, r := range term {
+			if text[pos+i] != r {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			best = len(term)
+		}
+	}
+	return best
+}
+
+// markRange 转义 text[start:end] 并用 <mark> 标出命中的搜索词
+func markRange(original, lower []rune, start, end int, terms [][]rune) string {
+	var b strings.Builder
+	for i := start; i < end; {
+		if n := matchAt(lower, i, terms); n > 0 && i+n <= end {
+			b.WriteString("<mark>")
+			b.WriteString(html.EscapeString(string(original[i : i+n])))
+			b.WriteString("</mark>")
+			i += n
+			continue
+		}
+		b.WriteString(html.EscapeString(string(original[i])))
+		i++
+	}
+	return b.String()
+}
+
+func lowerTerms(terms []string) [][]rune {
+	result := make([][]rune, 0, len(terms))
+	for _, term := range terms {
+		result = append(result, lowerRunes(term))
+	}
+	return result
+}
+
+// highlight 转义文本并标出全部命中的搜索词
+func highlight(text string, terms []string) string {
+	original := []rune(text)
+	return markRange(original, lowerRunes(text), 0, len(original), lowerTerms(terms))
+}
+
+// makeSnippet 截取第一个命中位置附近的文本作为摘要，结果已转义，命中词用 <mark> 标出
+func makeSnippet(text string, terms []string) string {
+	original := []rune(text)
+	lower := lowerRunes(text)
+	needles := lowerTerms(terms)
+
+	first := -1
+	for i := range lower {
+		if matchAt(lower, i, needles) > 0 {
+			first = i
+			break
+		}
+	}
+
+	start := 0
+	if first > snippetBefore {
+		start = first - snippetBefore
+	}
+	end := start + snippetBefore + snippetAfter
+	if end > len(original) {
+		end = len(original)
+	}
+
+	snippet := markRange(original, lower, start, end, needles)
+	if start > 0 {
+		snippet = "…" + snippet
+	}
+	if end < len(original) {
+		snippet += "…"
+	}
+	return snippet
+}
@@ -0,0 +1,99 @@
+package websocket
+
+import (
+	"github.com/MiXiaoAi/oinote/backend/internal/models"
+)
+
+// Audience 描述一条事件的接收范围，多个范围取并集
+type Audience struct {
+	All        bool   // 所有连接的客户端
+	UserIDs    []uint // 指定用户
+	ChannelIDs []uint // 频道成员（公开频道为所有人）
+	NoteIDs    []uint // 能访问笔记的用户（公开笔记为所有人）
+}
+
+// Everyone 所有连接的客户端
+func Everyone() Audience {
+	return Audience{All: true}
+}
+
+// ToUsers 指定用户
+func ToUsers(userIDs ...uint) Audience {
+	return Audience{UserIDs: userIDs}
+}
+
+// ToChannel 频道的正式成员，公开频道发给所有人
+func ToChannel(channelIDs ...uint) Audience {
+	return Audience{ChannelIDs: channelIDs}
+}
+
+// ToNote 笔记所有者、所属频道的成员和被共享的用户，公开笔记发给所有人
+func ToNote(noteIDs ...uint) Audience {
+	return Audience{NoteIDs: noteIDs}
+}
+
+// With 合并另一个接收范围
+func (a Audience) With(other Audience) Audience {
+	return Audience{
+		All:        a.All || other.All,
+		UserIDs:    append(append([]uint{}, a.UserIDs...), other.UserIDs...),
+		ChannelIDs: append(append([]uint{}, a.ChannelIDs...), other.ChannelIDs...),
+		NoteIDs:    append(append([]uint{}, a.NoteIDs...), other.NoteIDs...),
+	}
+}
+
+// resolve 根据频道成员和笔记归属把接收范围解析为用户集合，all 为 true 时发给所有客户端
+// 回收站中的频道和笔记同样参与解析，以便删除事件能送达原来的接收者
+func (h *Hub) resolve(audience Audience) (bool, map[uint]bool) {
+	if audience.All {
+		return true, nil
+	}
+
+	users := make(map[uint]bool)
+	for _, userID := range audience.UserIDs {
+		users[userID] = true
+	}
+
+	for _, channelID := range audience.ChannelIDs {
+		var channel models.Channel
+		if err := h.db.Unscoped().First(&channel, channelID).Error; err != nil {
+			continue
+		}
+		if channel.IsPublic {
+			return true, nil
+		}
+		h.addChannelMembers(users, channel.ID)
+	}
+
+	for _, noteID := range audience.NoteIDs {
+		var note models.Note
+		if err := h.db.Unscoped().First(&note, noteID).Error; err != nil {
+			continue
+		}
+		if note.IsPublic {
+			return true, nil
+		}
+		users[note.OwnerID] = true
+		if note.ChannelID != nil {
+			h.addChannelMembers(users, *note.ChannelID)
+		}
+		var sharedIDs []uint
+		h.db.Model(&models.NoteShare{}).Where("note_id = ?", note.ID).Pluck("user_id", &sharedIDs)
+		for _, userID := range sharedIDs {
+			users[userID] = true
+		}
+	}
+
+	return false, users
+}
+
+// addChannelMembers 把频道的正式成员加入用户集合
+func (h *Hub) addChannelMembers(users map[uint]bool, channelID uint) {
+	var memberIDs []uint
+	h.db.Model(&models.ChannelMember{}).
+		Where("channel_id = ? AND status = ?", channelID, models.MemberStatusActive).
+		Pluck("user_id", &memberIDs)
+	for _, userID := range memberIDs {
+		users[userID] = true
+	}
+}
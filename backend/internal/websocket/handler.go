@@ -0,0 +1,50 @@
+package websocket
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/MiXiaoAi/oinote/backend/internal/middleware"
+	"github.com/gofiber/websocket/v2"
+)
+
+// CloseUnauthorized 令牌缺失或无效时使用的关闭码，客户端收到后不应自动重连
+const CloseUnauthorized = 4401
+
+// HandleWebSocket 处理实时事件连接
+// 使用 JWT 认证，浏览器无法为 WebSocket 设置请求头，因此同时支持 token 查询参数；
+// 断线重连时携带 since（最后收到的事件序号）和 epoch（服务端纪元）以补发错过的事件
+func HandleWebSocket(conn *websocket.Conn, hub *Hub) {
+	token := conn.Query("token")
+	if token == "" {
+		token = strings.TrimPrefix(conn.Headers("Authorization"), "Bearer ")
+	}
+
+	userID, _, err := middleware.ParseUserToken(token)
+	if token == "" || err != nil {
+		conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(CloseUnauthorized, "unauthorized"), time.Now().Add(time.Second))
+		conn.Close()
+		return
+	}
+
+	client := &Client{
+		ID:     fmt.Sprintf("%d-%d", userID, time.Now().UnixNano()),
+		Conn:   conn,
+		Send:   make(chan []byte, 256),
+		UserID: userID,
+	}
+	if since, err := strconv.ParseUint(conn.Query("since"), 10, 64); err == nil {
+		client.Resume = true
+		client.Since = since
+		client.Epoch = conn.Query("epoch")
+	}
+
+	hub.Register(client)
+	defer hub.Unregister(client)
+
+	// 启动读写管道
+	go client.WritePump()
+	client.ReadPump(hub)
+}
@@ -3,57 +3,112 @@ package websocket
 import (
 	"encoding/json"
 	"log"
+	"strconv"
 	"time"
 
 	"github.com/gofiber/websocket/v2"
+	"gorm.io/gorm"
 )
 
+// 重放缓冲区保留的最近事件数量
+const replayBufferSize = 1000
+
 // Client 表示一个 WebSocket 客户端
 type Client struct {
 	ID     string
 	Conn   *websocket.Conn
 	Send   chan []byte
 	UserID uint
+
+	// 断线重连时客户端最后收到的事件序号和服务端纪元，Resume 为 false 表示新连接
+	Resume bool
+	Since  uint64
+	Epoch  string
 }
 
 // Message 表示要广播的消息
 type Message struct {
-	Type      string      `json:"type"`      // "note", "channel", "channel_message"
-	Action    string      `json:"action"`    // "create", "update", "delete"
-	Data      interface{} `json:"data"`
-	Timestamp int64       `json:"timestamp"`
+	Seq       uint64          `json:"seq"`       // 单调递增的事件序号，系统消息和临时事件为 0
+	Type      string          `json:"type"`      // "note", "channel", "channel_message"
+	Action    string          `json:"action"`    // "create", "update", "delete"
+	Data      json.RawMessage `json:"data"`
+	Timestamp int64           `json:"timestamp"` // 毫秒时间戳
+}
+
+// Hello 连接建立后发送的第一条系统消息
+// Resumed 为 false 表示缓冲区已不包含客户端错过的全部事件，客户端需要重新拉取数据
+type Hello struct {
+	Seq     uint64 `json:"seq"`
+	Epoch   string `json:"epoch"`
+	Resumed bool   `json:"resumed"`
+}
+
+// delivery 待投递的消息及其接收者
+type delivery struct {
+	message Message
+	data    []byte
+	all       bool          // 发给所有客户端
+	users     map[uint]bool // 接收消息的用户
+	transient bool          // 临时事件（在线状态、正在输入），不分配序号也不进入重放缓冲区
+}
+
+// visibleTo 判断用户是否在消息的接收范围内
+func (d *delivery) visibleTo(userID uint) bool {
+	return d.all || d.users[userID]
 }
 
 // Hub 管理 WebSocket 连接
 type Hub struct {
+	// 用于解析接收范围
+	db *gorm.DB
+
 	// 注册的客户端
 	clients map[*Client]bool
 
-	// 从客户端接收的消息
-	broadcast chan []byte
+	// 待投递的消息
+	broadcast chan *delivery
 
 	// 注册客户端请求
 	register chan *Client
 
 	// 注销客户端请求
 	unregister chan *Client
+
+	// 服务端纪元，重启后序号从头开始，客户端据此判断能否续传
+	epoch string
+
+	// 最近分配的事件序号
+	seq uint64
+
+	// 最近的事件，按序号递增
+	history []*delivery
+
+	// 用户在线状态和正在输入的状态
+	presence *presence
 }
 
 // NewHub 创建一个新的 Hub
-func NewHub() *Hub {
+func NewHub(db *gorm.DB) *Hub {
 	return &Hub{
-		broadcast:  make(chan []byte),
+		db:         db,
+		broadcast:  make(chan *delivery),
 		register:   make(chan *Client),
 		unregister: make(chan *Client),
 		clients:    make(map[*Client]bool),
+		epoch:      strconv.FormatInt(time.Now().UnixNano(), 36),
+		presence:   newPresence(),
 	}
 }
 
 // Run 启动 Hub
 func (h *Hub) Run() {
+	go h.sweepPresence()
+
 	for {
 		select {
 		case client := <-h.register:
+			// 先补发错过的事件再加入客户端列表，保证事件按序号送达
+			h.replay(client)
 			h.clients[client] = true
 			log.Printf("WebSocket 客户端已连接: %s (用户ID: %d)", client.ID, client.UserID)
 
@@ -65,10 +120,32 @@ func (h *Hub) Run() {
 			}
 
 		case message := <-h.broadcast:
-			// 向所有连接的客户端广播消息
+			if !message.transient {
+				h.seq++
+				message.message.Seq = h.seq
+			}
+			message.message.Timestamp = time.Now().UnixMilli()
+			data, err := json.Marshal(message.message)
+			if err != nil {
+				log.Printf("WebSocket 广播消息失败: %v", err)
+				continue
+			}
+			message.data = data
+
+			if !message.transient {
+				h.history = append(h.history, message)
+				if len(h.history) > replayBufferSize {
+					h.history = h.history[len(h.history)-replayBufferSize:]
+				}
+			}
+
+			// 只投递给接收范围内的客户端
 			for client := range h.clients {
+				if !message.visibleTo(client.UserID) {
+					continue
+				}
 				select {
-				case client.Send <- message:
+				case client.Send <- message.data:
 				default:
 					// 发送失败，关闭连接
 					close(client.Send)
@@ -79,32 +156,93 @@ func (h *Hub) Run() {
 	}
 }
 
+// replay 发送连接确认，并在可以续传时补发客户端错过的事件
+func (h *Hub) replay(client *Client) {
+	var missed [][]byte
+	resumed := client.Resume && client.Epoch == h.epoch && client.Since <= h.seq
+	if resumed && client.Since < h.seq {
+		// 缓冲区最早的事件必须紧接在客户端最后收到的事件之后
+		if len(h.history) == 0 || h.history[0].message.Seq > client.Since+1 {
+			resumed = false
+		}
+	}
+	if resumed {
+		for _, message := range h.history {
+			if message.message.Seq > client.Since && message.visibleTo(client.UserID) {
+				missed = append(missed, message.data)
+			}
+		}
+		// 补发的事件放不进发送队列时同样要求客户端重新拉取
+		if len(missed) >= cap(client.Send) {
+			resumed = false
+			missed = nil
+		}
+	}
+
+	hello, _ := json.Marshal(Hello{Seq: h.seq, Epoch: h.epoch, Resumed: resumed})
+	data, _ := json.Marshal(Message{
+		Type:      "system",
+		Action:    "hello",
+		Data:      hello,
+		Timestamp: time.Now().UnixMilli(),
+	})
+	client.Send <- data
+	for _, message := range missed {
+		client.Send <- message
+	}
+
+	if client.Resume {
+		log.Printf("WebSocket 客户端续传: %s, since=%d, 补发 %d 条, resumed=%v", client.ID, client.Since, len(missed), resumed)
+	}
+}
+
+// Epoch 返回服务端纪元
+func (h *Hub) Epoch() string {
+	return h.epoch
+}
+
 // Register 注册客户端
 func (h *Hub) Register(client *Client) {
 	h.register <- client
+	h.connect(client)
 }
 
 // Unregister 注销客户端
 func (h *Hub) Unregister(client *Client) {
 	h.unregister <- client
+	h.disconnect(client)
 }
 
 // BroadcastMessage 广播消息到所有客户端
 func (h *Hub) BroadcastMessage(msgType, action string, data interface{}) {
-	message := Message{
-		Type:      msgType,
-		Action:    action,
-		Data:      data,
-		Timestamp: 0,
-	}
+	h.Publish(Everyone(), msgType, action, data)
+}
 
-	bytes, err := json.Marshal(message)
+// Publish 把消息发送给指定接收范围内的客户端
+func (h *Hub) Publish(audience Audience, msgType, action string, data interface{}) {
+	h.publish(audience, msgType, action, data, false)
+}
+
+// publish 序列化并投递消息，transient 为 true 时不进入重放缓冲区
+func (h *Hub) publish(audience Audience, msgType, action string, data interface{}, transient bool) {
+	// 在调用方协程中序列化数据，序号和时间戳由 Run 统一分配
+	bytes, err := json.Marshal(data)
 	if err != nil {
 		log.Printf("WebSocket 广播消息失败: %v", err)
 		return
 	}
 
-	h.broadcast <- bytes
+	all, users := h.resolve(audience)
+	if !all && len(users) == 0 {
+		return
+	}
+
+	h.broadcast <- &delivery{
+		message:   Message{Type: msgType, Action: action, Data: bytes},
+		all:       all,
+		users:     users,
+		transient: transient,
+	}
 }
 
 // WritePump 从 Hub 读取消息并写入 WebSocket 连接
@@ -124,20 +262,9 @@ func (c *Client) WritePump() {
 				return
 			}
 
+			// 每条消息单独一帧，客户端按帧解析 JSON
 			c.Conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
-			w, err := c.Conn.NextWriter(websocket.TextMessage)
-			if err != nil {
-				return
-			}
-			w.Write(message)
-
-			// 排队消息
-			for len(c.Send) > 0 {
-				w.Write([]byte{'\n'})
-				w.Write(<-c.Send)
-			}
-
-			if err := w.Close(); err != nil {
+			if err := c.Conn.WriteMessage(websocket.TextMessage, message); err != nil {
 				return
 			}
 		case <-ticker.C:
@@ -157,12 +284,13 @@ func (c *Client) ReadPump(hub *Hub) {
 	}()
 
 	for {
-		_, _, err := c.Conn.ReadMessage()
+		_, message, err := c.Conn.ReadMessage()
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 				log.Printf("WebSocket 读取错误: %v", err)
 			}
 			break
 		}
+		hub.handleClientMessage(c, message)
 	}
 }
@@ -0,0 +1,368 @@
+package websocket
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/MiXiaoAi/oinote/backend/internal/models"
+)
+
+// 在线状态
+const (
+	PresenceOnline  = "online"
+	PresenceIdle    = "idle"
+	PresenceOffline = "offline"
+)
+
+const (
+	// 超过该时间没有活动的连接视为空闲
+	idleAfter = 5 * time.Minute
+	// 客户端需要在该时间内再次发送正在输入，否则视为停止输入
+	typingTimeout = 6 * time.Second
+	// 检查空闲和输入超时的间隔
+	presenceSweepInterval = time.Second
+)
+
+// PresenceInfo 用户的在线状态
+type PresenceInfo struct {
+	UserID     uint       `json:"user_id"`
+	Status     string     `json:"status"`
+	LastActive *time.Time `json:"last_active"`
+}
+
+// TypingEvent 正在输入事件
+type TypingEvent struct {
+	ChannelID uint   `json:"channel_id"`
+	UserID    uint   `json:"user_id"`
+	Username  string `json:"username"`
+}
+
+// connPresence 单个连接的活动状态
+type connPresence struct {
+	idle       bool // 客户端主动报告空闲（如页面进入后台）
+	lastActive time.Time
+}
+
+// userPresence 用户所有连接的活动状态，status 为最近一次通知的状态
+type userPresence struct {
+	conns      map[*Client]*connPresence
+	status     string
+	lastActive time.Time
+}
+
+// presence 在线状态和正在输入的状态，由 HTTP 请求和 WebSocket 读协程并发访问
+type presence struct {
+	mu     sync.Mutex
+	users  map[uint]*userPresence
+	typing map[uint]map[uint]time.Time // 频道ID -> 用户ID -> 过期时间
+}
+
+func newPresence() *presence {
+	return &presence{
+		users:  make(map[uint]*userPresence),
+		typing: make(map[uint]map[uint]time.Time),
+	}
+}
+
+// compute 根据所有连接计算用户当前的状态：只要有一个连接活跃即为在线
+func (u *userPresence) compute(now time.Time) string {
+	if len(u.conns) == 0 {
+		return PresenceOffline
+	}
+	for _, conn := range u.conns {
+		if !conn.idle && now.Sub(conn.lastActive) < idleAfter {
+			return PresenceOnline
+		}
+	}
+	return PresenceIdle
+}
+
+// info 生成对外的状态信息，调用方需持有锁
+func (u *userPresence) info(userID uint) PresenceInfo {
+	lastActive := u.lastActive
+	return PresenceInfo{UserID: userID, Status: u.status, LastActive: &lastActive}
+}
+
+// update 重新计算用户状态，状态变化时返回需要通知的状态信息，调用方需持有锁
+func (p *presence) update(userID uint, now time.Time) *PresenceInfo {
+	user := p.users[userID]
+	status := user.compute(now)
+	if status == user.status {
+		return nil
+	}
+	user.status = status
+	info := user.info(userID)
+	if status == PresenceOffline {
+		delete(p.users, userID)
+	}
+	return &info
+}
+
+// connect 记录新连接
+func (h *Hub) connect(client *Client) {
+	now := time.Now()
+
+	h.presence.mu.Lock()
+	user := h.presence.users[client.UserID]
+	if user == nil {
+		user = &userPresence{conns: make(map[*Client]*connPresence), status: PresenceOffline}
+		h.presence.users[client.UserID] = user
+	}
+	user.conns[client] = &connPresence{lastActive: now}
+	user.lastActive = now
+	changed := h.presence.update(client.UserID, now)
+	h.presence.mu.Unlock()
+
+	h.publishPresence(changed)
+}
+
+// disconnect 移除连接，可以重复调用
+func (h *Hub) disconnect(client *Client) {
+	h.presence.mu.Lock()
+	user := h.presence.users[client.UserID]
+	if user == nil || user.conns[client] == nil {
+		h.presence.mu.Unlock()
+		return
+	}
+	delete(user.conns, client)
+	changed := h.presence.update(client.UserID, time.Now())
+	// 最后一个连接断开时清除该用户的正在输入状态
+	var stopped []uint
+	if changed != nil && changed.Status == PresenceOffline {
+		stopped = h.presence.clearTyping(client.UserID)
+	}
+	h.presence.mu.Unlock()
+
+	h.publishPresence(changed)
+	for _, channelID := range stopped {
+		h.publishTyping(channelID, client.UserID, false)
+	}
+}
+
+// touch 记录连接的活动，idle 为 true 表示客户端报告进入空闲
+func (h *Hub) touch(client *Client, idle bool) {
+	now := time.Now()
+
+	h.presence.mu.Lock()
+	user := h.presence.users[client.UserID]
+	if user == nil || user.conns[client] == nil {
+		h.presence.mu.Unlock()
+		return
+	}
+	conn := user.conns[client]
+	conn.idle = idle
+	if !idle {
+		conn.lastActive = now
+		user.lastActive = now
+	}
+	changed := h.presence.update(client.UserID, now)
+	h.presence.mu.Unlock()
+
+	h.publishPresence(changed)
+}
+
+// TouchUser 记录用户通过 HTTP 请求产生的活动（如发送消息），刷新其所有未报告空闲的连接
+func (h *Hub) TouchUser(userID uint) {
+	now := time.Now()
+
+	h.presence.mu.Lock()
+	user := h.presence.users[userID]
+	if user == nil {
+		h.presence.mu.Unlock()
+		return
+	}
+	for _, conn := range user.conns {
+		if !conn.idle {
+			conn.lastActive = now
+		}
+	}
+	user.lastActive = now
+	changed := h.presence.update(userID, now)
+	h.presence.mu.Unlock()
+
+	h.publishPresence(changed)
+}
+
+// Presence 返回一组用户的在线状态，没有连接的用户为离线
+func (h *Hub) Presence(userIDs []uint) map[uint]PresenceInfo {
+	h.presence.mu.Lock()
+	defer h.presence.mu.Unlock()
+
+	result := make(map[uint]PresenceInfo, len(userIDs))
+	for _, userID := range userIDs {
+		if user := h.presence.users[userID]; user != nil {
+			result[userID] = user.info(userID)
+		} else {
+			result[userID] = PresenceInfo{UserID: userID, Status: PresenceOffline}
+		}
+	}
+	return result
+}
+
+// ChannelPresence 返回频道中在线或空闲的成员及正在输入的成员
+func (h *Hub) ChannelPresence(channelID uint) ([]PresenceInfo, []uint) {
+	var memberIDs []uint
+	h.db.Model(&models.ChannelMember{}).
+		Where("channel_id = ? AND status = ?", channelID, models.MemberStatusActive).
+		Pluck("user_id", &memberIDs)
+
+	h.presence.mu.Lock()
+	defer h.presence.mu.Unlock()
+
+	here := []PresenceInfo{}
+	for _, userID := range memberIDs {
+		if user := h.presence.users[userID]; user != nil {
+			here = append(here, user.info(userID))
+		}
+	}
+
+	typing := []uint{}
+	now := time.Now()
+	for userID, expires := range h.presence.typing[channelID] {
+		if now.Before(expires) {
+			typing = append(typing, userID)
+		}
+	}
+	return here, typing
+}
+
+// SetTyping 更新用户在频道中的输入状态，开始输入需要在超时前重复发送
+// 调用方需确认用户是频道成员
+func (h *Hub) SetTyping(channelID, userID uint, typing bool) {
+	h.presence.mu.Lock()
+	users := h.presence.typing[channelID]
+	_, wasTyping := users[userID]
+	if typing {
+		if users == nil {
+			users = make(map[uint]time.Time)
+			h.presence.typing[channelID] = users
+		}
+		users[userID] = time.Now().Add(typingTimeout)
+	} else if wasTyping {
+		delete(users, userID)
+		if len(users) == 0 {
+			delete(h.presence.typing, channelID)
+		}
+	}
+	h.presence.mu.Unlock()
+
+	// 持续输入时只在开始时通知一次
+	if typing != wasTyping {
+		h.publishTyping(channelID, userID, typing)
+	}
+}
+
+// clearTyping 清除用户在所有频道的输入状态，调用方需持有锁，返回受影响的频道
+func (p *presence) clearTyping(userID uint) []uint {
+	var channelIDs []uint
+	for channelID, users := range p.typing {
+		if _, ok := users[userID]; ok {
+			delete(users, userID)
+			if len(users) == 0 {
+				delete(p.typing, channelID)
+			}
+			channelIDs = append(channelIDs, channelID)
+		}
+	}
+	return channelIDs
+}
+
+// sweepPresence 定期把长时间没有活动的用户标记为空闲，并结束超时的正在输入状态
+func (h *Hub) sweepPresence() {
+	ticker := time.NewTicker(presenceSweepInterval)
+	defer ticker.Stop()
+
+	type typingKey struct{ channelID, userID uint }
+
+	for now := range ticker.C {
+		var changed []*PresenceInfo
+		var expired []typingKey
+
+		h.presence.mu.Lock()
+		for userID := range h.presence.users {
+			if info := h.presence.update(userID, now); info != nil {
+				changed = append(changed, info)
+			}
+		}
+		for channelID, users := range h.presence.typing {
+			for userID, expires := range users {
+				if now.After(expires) {
+					delete(users, userID)
+					expired = append(expired, typingKey{channelID, userID})
+				}
+			}
+			if len(users) == 0 {
+				delete(h.presence.typing, channelID)
+			}
+		}
+		h.presence.mu.Unlock()
+
+		for _, info := range changed {
+			h.publishPresence(info)
+		}
+		for _, key := range expired {
+			h.publishTyping(key.channelID, key.userID, false)
+		}
+	}
+}
+
+// publishPresence 把状态变化通知给用户自己和与其同在一个频道的用户
+func (h *Hub) publishPresence(info *PresenceInfo) {
+	if info == nil {
+		return
+	}
+	var channelIDs []uint
+	h.db.Model(&models.ChannelMember{}).
+		Where("user_id = ? AND status = ?", info.UserID, models.MemberStatusActive).
+		Pluck("channel_id", &channelIDs)
+
+	h.publish(ToChannel(channelIDs...).With(ToUsers(info.UserID)), "presence", "update", info, true)
+}
+
+// publishTyping 通知频道成员某个用户开始或停止输入
+func (h *Hub) publishTyping(channelID, userID uint, typing bool) {
+	event := TypingEvent{ChannelID: channelID, UserID: userID}
+	var user models.User
+	if err := h.db.Select("username").First(&user, userID).Error; err == nil {
+		event.Username = user.Username
+	}
+
+	action := "stop"
+	if typing {
+		action = "start"
+	}
+	h.publish(ToChannel(channelID), "typing", action, event, true)
+}
+
+// clientMessage 客户端通过 WebSocket 发送的消息
+//
+//	{"type": "typing", "channel_id": 1, "typing": true}
+//	{"type": "presence", "status": "idle"}
+//	{"type": "ping"}
+type clientMessage struct {
+	Type      string `json:"type"`
+	ChannelID uint   `json:"channel_id"`
+	Typing    bool   `json:"typing"`
+	Status    string `json:"status"`
+}
+
+// handleClientMessage 处理客户端上报的在线状态和输入状态，任何消息都视为一次活动
+func (h *Hub) handleClientMessage(client *Client, data []byte) {
+	var message clientMessage
+	if err := json.Unmarshal(data, &message); err != nil {
+		return
+	}
+
+	switch message.Type {
+	case "presence":
+		h.touch(client, message.Status == PresenceIdle)
+	case "typing":
+		h.touch(client, false)
+		if message.ChannelID != 0 && models.IsChannelMember(h.db, message.ChannelID, client.UserID) {
+			h.SetTyping(message.ChannelID, client.UserID, message.Typing)
+		}
+	default:
+		h.touch(client, false)
+	}
+}
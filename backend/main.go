@@ -1,11 +1,8 @@
 package main
 
 import (
-	"fmt"
 	"log"
 	"os"
-	"strconv"
-	"time"
 
 	handlers "github.com/MiXiaoAi/oinote/backend/api"
 	ws "github.com/MiXiaoAi/oinote/backend/internal/websocket"
@@ -27,11 +24,11 @@ func main() {
 	db := config.DB
 
 	// 初始化 WebSocket Hub
-	wsHub := ws.NewHub()
+	wsHub := ws.NewHub(db)
 	go wsHub.Run()
 
 	// 初始化协同编辑服务器
-	yjsServer := collab.NewYjsServer()
+	yjsServer := collab.NewYjsServer(db, wsHub)
 
 	// 初始化 Fiber
 	app := fiber.New(fiber.Config{
@@ -41,7 +38,7 @@ func main() {
 	app.Use(cors.New(cors.Config{
 		AllowOrigins:     "*",
 		AllowMethods:     "GET,POST,PUT,DELETE,OPTIONS,HEAD",
-		AllowHeaders:     "Origin,Content-Type,Accept,Authorization,Range",
+		AllowHeaders:     "Origin,Content-Type,Accept,Authorization,Range,Last-Event-ID,X-Share-Password",
 		ExposeHeaders:    "Content-Length,Accept-Ranges,Content-Range",
 		AllowCredentials: false,
 	}))
@@ -55,11 +52,19 @@ func main() {
 	app.Get("/media/*", handlers.ServeMediaFile)
 
 	// Handlers
-	authHandler := handlers.NewAuthHandler(db)
-	channelHandler := handlers.NewChannelHandler(db, wsHub)
-	noteHandler := handlers.NewNoteHandler(db, wsHub)
+	authHandler := handlers.NewAuthHandler(db, yjsServer)
+	channelHandler := handlers.NewChannelHandler(db, wsHub, yjsServer)
+	noteHandler := handlers.NewNoteHandler(db, wsHub, yjsServer)
 	fileHandler := handlers.NewFileHandler(db)
 	aiHandler := handlers.NewAIHandler(db)
+	trashHandler := handlers.NewTrashHandler(db, wsHub, yjsServer)
+	notificationHandler := handlers.NewNotificationHandler(db, wsHub)
+	folderHandler := handlers.NewFolderHandler(db, wsHub, yjsServer)
+	searchHandler := handlers.NewSearchHandler(db)
+	eventsHandler := handlers.NewEventsHandler(wsHub)
+
+	// 定期清除超过保留期限的回收站内容
+	go trashHandler.PurgeExpired()
 
 	// WebSocket 路由
 	app.Use("/ws", func(c *fiber.Ctx) error {
@@ -71,35 +76,7 @@ func main() {
 	})
 
 	app.Get("/ws", websocket.New(func(c *websocket.Conn) {
-		// 获取用户ID
-		userIDStr := c.Query("userId")
-		if userIDStr == "" {
-			c.Close()
-			return
-		}
-
-		userID, err := strconv.ParseUint(userIDStr, 10, 64)
-		if err != nil {
-			c.Close()
-			return
-		}
-
-		// 生成唯一的连接ID
-		connID := fmt.Sprintf("%s-%d", userIDStr, time.Now().UnixNano())
-
-		client := &ws.Client{
-			ID:     connID,
-			Conn:   c,
-			Send:   make(chan []byte, 256),
-			UserID: uint(userID),
-		}
-
-		wsHub.Register(client)
-		defer wsHub.Unregister(client)
-
-		// 启动读写管道
-		go client.WritePump()
-		client.ReadPump(wsHub)
+		ws.HandleWebSocket(c, wsHub)
 	}))
 
 	// 协同编辑 WebSocket 路由
@@ -107,6 +84,11 @@ func main() {
 		collab.HandleCollabWebSocket(c, yjsServer)
 	}))
 
+	// 标准 y-websocket 客户端连接 /ws/collab/{笔记ID}，使用二进制协议
+	app.Get("/ws/collab/:room", websocket.New(func(c *websocket.Conn) {
+		collab.HandleCollabWebSocket(c, yjsServer)
+	}))
+
 	// 路由组
 	r := app.Group("/api")
 
@@ -115,14 +97,24 @@ func main() {
 	r.Post("/login", authHandler.Login)
 	r.Post("/auth/change-password", authHandler.ChangePassword)
 	r.Get("/public/notes", noteHandler.GetPublicNotes)
+	r.Get("/s/:token", noteHandler.ResolveShareLink) // 通过分享链接访问笔记
+
+	// 实时事件流 (SSE)，供无法建立 WebSocket 连接的客户端使用，自行处理认证
+	r.Get("/events", middleware.StreamAuth, eventsHandler.Stream)
 
 	// 可选认证路由 (可选登录，支持访客和登录用户访问)
 	optional := r.Group("/", middleware.OptionalAuth)
 	optional.Get("/public/channels", channelHandler.GetPublicChannels)
 	optional.Get("/channels/:id", channelHandler.GetChannel)
 	optional.Get("/channels/:id/messages", channelHandler.GetChannelMessages)
+	optional.Get("/channels/:id/messages/:messageId/thread", channelHandler.GetThread)
+	optional.Get("/notes/graph", middleware.AuthRequired, noteHandler.GetNoteGraph) // 关系图需要登录，路由必须在notes/:id之前
+	optional.Get("/notes/shared", middleware.AuthRequired, noteHandler.GetSharedNotes) // 共享给我的笔记需要登录，路由必须在notes/:id之前
 	optional.Get("/notes/search", noteHandler.SearchNotes) // 搜索路由必须在notes/:id之前
+	optional.Get("/search", searchHandler.Search)
 	optional.Get("/notes/:id", noteHandler.GetNote)
+	optional.Get("/notes/:id/backlinks", noteHandler.GetNoteBacklinks)
+	optional.Get("/notes/:id/comments", noteHandler.GetNoteComments)
 	optional.Get("/notes", noteHandler.GetNotes) // 允许访客查看公开笔记
 
 	// 私有路由 (需要登录)
@@ -134,13 +126,26 @@ func main() {
 	protected.Post("/channels", channelHandler.CreateChannel)
 	protected.Get("/channels", channelHandler.GetUserChannels)
 	protected.Post("/channels/:id/messages", channelHandler.CreateChannelMessage)
+	protected.Post("/channels/:id/messages/:messageId/replies", channelHandler.CreateChannelMessage)
 	protected.Put("/channels/:id", channelHandler.UpdateChannel)
 	protected.Delete("/channels/:id", channelHandler.DeleteChannel)
+	protected.Put("/channels/:id/messages/:messageId", channelHandler.UpdateChannelMessage)
 	protected.Delete("/channels/:id/messages/:messageId", channelHandler.DeleteChannelMessage)
+	protected.Get("/channels/:id/messages/:messageId/history", channelHandler.GetChannelMessageHistory)
+	protected.Post("/channels/:id/messages/:messageId/reactions", channelHandler.AddMessageReaction)
+	protected.Delete("/channels/:id/messages/:messageId/reactions/:emoji", channelHandler.RemoveMessageReaction)
 	protected.Put("/channels/:id/messages/:messageId/highlight", channelHandler.HighlightMessage)
+	protected.Post("/channels/:id/messages/:messageId/restore", trashHandler.RestoreChannelMessage)
+	protected.Get("/channels/:id/trash", trashHandler.GetChannelTrash)
 	protected.Post("/channels/invite", channelHandler.InviteUser)
+	protected.Get("/direct", channelHandler.GetDirectConversations)
+	protected.Post("/direct", channelHandler.CreateDirectConversation)
 	protected.Put("/channels/:id/members/:userId", channelHandler.UpdateMemberRole)
 	protected.Delete("/channels/:id/members/:userId", channelHandler.RemoveMember)
+	protected.Get("/channels/:id/members", channelHandler.GetChannelMembers)
+	protected.Get("/channels/:id/presence", channelHandler.GetChannelPresence)
+	protected.Post("/channels/:id/typing", channelHandler.SetTyping)
+	protected.Post("/channels/:id/read", channelHandler.MarkChannelRead)
 	protected.Post("/channels/:id/join", channelHandler.JoinChannelRequest)
 	protected.Post("/channels/approvals", channelHandler.HandleMemberStatus)
 	protected.Post("/channels/approvals/approve", channelHandler.HandleMemberStatus)
@@ -152,6 +157,40 @@ func main() {
 	protected.Post("/notes", noteHandler.CreateNote)
 	protected.Put("/notes/:id", noteHandler.UpdateNote)
 	protected.Delete("/notes/:id", noteHandler.DeleteNote)
+	protected.Put("/notes/:id/folder", noteHandler.MoveNote)
+	protected.Get("/notes/:id/shares", noteHandler.GetNoteShares)
+	protected.Post("/notes/:id/shares", noteHandler.ShareNote)
+	protected.Delete("/notes/:id/shares/:userId", noteHandler.RevokeNoteShare)
+	protected.Get("/notes/:id/share-links", noteHandler.GetShareLinks)
+	protected.Post("/notes/:id/share-links", noteHandler.CreateShareLink)
+	protected.Delete("/notes/:id/share-links/:linkId", noteHandler.RevokeShareLink)
+	protected.Post("/notes/:id/comments", noteHandler.CreateNoteComment)
+	protected.Put("/notes/:id/comments/:commentId", noteHandler.UpdateNoteComment)
+	protected.Post("/notes/:id/comments/:commentId/resolve", noteHandler.ResolveNoteComment)
+	protected.Post("/notes/:id/comments/:commentId/reopen", noteHandler.ReopenNoteComment)
+	protected.Delete("/notes/:id/comments/:commentId", noteHandler.DeleteNoteComment)
+	protected.Post("/notes/:id/reactions", noteHandler.AddNoteReaction)
+	protected.Delete("/notes/:id/reactions/:emoji", noteHandler.RemoveNoteReaction)
+	protected.Get("/notes/:id/revisions", noteHandler.GetNoteRevisions)
+	protected.Get("/notes/:id/revisions/diff", noteHandler.DiffNoteRevisions)
+	protected.Get("/notes/:id/revisions/:revisionId", noteHandler.GetNoteRevision)
+	protected.Post("/notes/:id/revisions/:revisionId/restore", noteHandler.RestoreNoteRevision)
+
+	protected.Get("/folders", folderHandler.GetFolders)
+	protected.Post("/folders", folderHandler.CreateFolder)
+	protected.Put("/folders/:id", folderHandler.UpdateFolder)
+	protected.Put("/folders/:id/move", folderHandler.MoveFolder)
+	protected.Delete("/folders/:id", folderHandler.DeleteFolder)
+
+	protected.Get("/notifications", notificationHandler.GetNotifications)
+	protected.Put("/notifications/:id/read", notificationHandler.MarkNotificationRead)
+	protected.Post("/notifications/read-all", notificationHandler.MarkAllNotificationsRead)
+	protected.Delete("/notifications/:id", notificationHandler.DeleteNotification)
+
+	protected.Get("/trash", trashHandler.GetTrash)
+	protected.Post("/trash/notes/:id/restore", trashHandler.RestoreNote)
+	protected.Post("/trash/channels/:id/restore", trashHandler.RestoreChannel)
+	protected.Post("/trash/folders/:id/restore", trashHandler.RestoreFolder)
 
 	protected.Post("/upload", fileHandler.Upload)
 
@@ -159,6 +198,8 @@ func main() {
 	admin := r.Group("/admin", middleware.AuthRequired, middleware.AdminRequired)
 	admin.Get("/ai-config", aiHandler.GetAIConfig)
 	admin.Put("/ai-config", aiHandler.UpdateAIConfig)
+	admin.Get("/trash-config", trashHandler.GetTrashConfig)
+	admin.Put("/trash-config", trashHandler.UpdateTrashConfig)
 	admin.Get("/stats", authHandler.GetStats)
 	admin.Get("/users", authHandler.GetAllUsers)
 	admin.Put("/users/:id/role", authHandler.UpdateUserRole)
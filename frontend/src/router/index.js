@@ -22,6 +22,11 @@ const router = createRouter({
             component: () => import('../views/Admin.vue'),
             meta: { requiresAuth: true, requiresAdmin: true },
         },
+        {
+            path: '/s/:token',
+            name: 'shared-note',
+            component: () => import('../views/SharedNote.vue'),
+        },
         {
             path: '/login',
             name: 'login',
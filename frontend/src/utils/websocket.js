@@ -1,4 +1,5 @@
-import { getWebSocketUrl } from './urlHelper';
+import { getWebSocketUrl, getApiBaseUrl } from './urlHelper';
+import api from '../api/axios';
 
 class WebSocketClient {
   constructor(url) {
@@ -10,10 +11,17 @@ class WebSocketClient {
     this.reconnectAttempts = 0;
     this.listeners = new Map();
     this.isConnecting = false;
+    // 最后收到的事件序号和服务端纪元，重连时用于补发错过的事件
+    this.lastSeq = null;
+    this.epoch = null;
+    // WebSocket 连续握手失败（如代理拦截升级请求）时改用 SSE
+    this.failedHandshakes = 0;
+    this.maxFailedHandshakes = 2;
+    this.eventSource = null;
   }
 
   connect(userId) {
-    if (this.isConnecting || (this.ws && this.ws.readyState === WebSocket.OPEN)) {
+    if (this.isConnecting || this.eventSource || (this.ws && this.ws.readyState === WebSocket.OPEN)) {
       return;
     }
 
@@ -24,12 +32,24 @@ class WebSocketClient {
     this.isConnecting = true;
 
     try {
-      const wsUrl = `${this.url}?userId=${userId}`;
+      const token = localStorage.getItem('token');
+      if (!token) {
+        this.isConnecting = false;
+        return;
+      }
+
+      let wsUrl = `${this.url}?token=${encodeURIComponent(token)}`;
+      if (this.lastSeq !== null && this.epoch) {
+        wsUrl += `&since=${this.lastSeq}&epoch=${encodeURIComponent(this.epoch)}`;
+      }
       this.ws = new WebSocket(wsUrl);
+      let opened = false;
 
       this.ws.onopen = () => {
+        opened = true;
         this.isConnecting = false;
         this.reconnectAttempts = 0;
+        this.failedHandshakes = 0;
         this.emit('connected');
       };
 
@@ -44,6 +64,14 @@ class WebSocketClient {
       this.ws.onclose = (event) => {
         this.isConnecting = false;
         this.emit('disconnected');
+        // 令牌无效时重连没有意义
+        if (event.code === 4401) {
+          return;
+        }
+        if (!opened && ++this.failedHandshakes >= this.maxFailedHandshakes) {
+          this.connectEventSource();
+          return;
+        }
         this.attemptReconnect();
       };
 
@@ -57,9 +85,80 @@ class WebSocketClient {
     }
   }
 
+  // 通过 SSE 接收同样的事件，浏览器断线后会自动重连并带上 Last-Event-ID
+  connectEventSource() {
+    const token = localStorage.getItem('token');
+    if (!token || typeof EventSource === 'undefined') {
+      return;
+    }
+
+    let url = `${getApiBaseUrl()}/events?token=${encodeURIComponent(token)}`;
+    if (this.lastSeq !== null && this.epoch) {
+      url += `&lastEventId=${encodeURIComponent(`${this.epoch}-${this.lastSeq}`)}`;
+    }
+    this.eventSource = new EventSource(url);
+
+    this.eventSource.onopen = () => {
+      this.emit('connected');
+    };
+
+    this.eventSource.onmessage = (event) => {
+      try {
+        const message = JSON.parse(event.data);
+        this.handleMessage(message);
+      } catch (error) {
+      }
+    };
+
+    this.eventSource.onerror = (error) => {
+      this.emit('disconnected');
+      // 认证失败等情况下浏览器不再重连
+      if (this.eventSource && this.eventSource.readyState === EventSource.CLOSED) {
+        this.eventSource = null;
+      }
+    };
+  }
+
+  // 向服务端发送消息，连接未建立或使用 SSE 时返回 false
+  send(data) {
+    if (this.ws && this.ws.readyState === WebSocket.OPEN) {
+      this.ws.send(JSON.stringify(data));
+      return true;
+    }
+    return false;
+  }
+
+  // 报告在频道中开始或停止输入，开始输入需要每隔几秒重复发送
+  sendTyping(channelId, typing = true) {
+    if (!this.send({ type: 'typing', channel_id: Number(channelId), typing })) {
+      api.post(`/channels/${channelId}/typing`, { typing }).catch(() => {});
+    }
+  }
+
+  // 报告在线状态，页面进入后台时为 idle
+  sendPresence(status) {
+    this.send({ type: 'presence', status });
+  }
+
   handleMessage(message) {
     const { type, action, data } = message;
 
+    if (type === 'system' && action === 'hello') {
+      // 服务端无法补发全部错过的事件，通知页面重新拉取数据
+      if (this.lastSeq !== null && !data.resumed) {
+        this.emit('resync', message);
+      }
+      this.epoch = data.epoch;
+      if (!data.resumed) {
+        this.lastSeq = data.seq;
+      }
+      return;
+    }
+
+    if (message.seq) {
+      this.lastSeq = message.seq;
+    }
+
     // 触发特定类型的事件 - 传递完整的消息对象
     const eventKey = `${type}_${action}`;
     this.emit(eventKey, message);
@@ -98,8 +197,16 @@ class WebSocketClient {
       this.ws = null;
     }
 
+    if (this.eventSource) {
+      this.eventSource.close();
+      this.eventSource = null;
+    }
+
     this.reconnectAttempts = 0;
+    this.failedHandshakes = 0;
     this.isConnecting = false;
+    this.lastSeq = null;
+    this.epoch = null;
   }
 
   on(event, callback) {
@@ -136,6 +243,13 @@ class WebSocketClient {
 // 创建全局 WebSocket 客户端实例
 const wsClient = new WebSocketClient(getWebSocketUrl());
 
+// 页面切到后台时报告空闲，回到前台时报告在线
+if (typeof document !== 'undefined') {
+  document.addEventListener('visibilitychange', () => {
+    wsClient.sendPresence(document.hidden ? 'idle' : 'online');
+  });
+}
+
 // 自动连接（如果用户已登录）
 export function connectWebSocket(userId) {
   if (userId) {
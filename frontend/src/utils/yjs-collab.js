@@ -17,6 +17,8 @@ export class YjsCollabClient {
     this.connected = false
     this.synced = false
     this.clientId = null
+    this.lastCursor = null
+    this.awarenessTimer = null
     
     // 用户颜色映射
     this.userColors = new Map()
@@ -55,7 +57,8 @@ export class YjsCollabClient {
    * 连接到服务器
    */
   connect() {
-    const url = `${this.wsUrl}?userId=${this.userId}&username=${encodeURIComponent(this.username)}&nickname=${encodeURIComponent(this.nickname)}&noteId=${this.noteId}`
+    const token = localStorage.getItem('token') || ''
+    const url = `${this.wsUrl}?token=${encodeURIComponent(token)}&noteId=${this.noteId}`
     
     this.ws = new WebSocket(url)
     
@@ -64,6 +67,10 @@ export class YjsCollabClient {
       
       // 请求同步
       this.requestSync()
+
+      // 服务器会移除超过 30 秒未续期的感知状态，每 15 秒续期一次
+      clearInterval(this.awarenessTimer)
+      this.awarenessTimer = setInterval(() => this.renewAwareness(), 15000)
       
       if (this.onConnect) {
         this.onConnect()
@@ -78,14 +85,21 @@ export class YjsCollabClient {
       console.error('协同编辑 WebSocket 错误:', error)
     }
     
-    this.ws.onclose = () => {
+    this.ws.onclose = (event) => {
       this.connected = false
       this.synced = false
+      clearInterval(this.awarenessTimer)
       
       if (this.onDisconnect) {
         this.onDisconnect()
       }
       
+      // 未授权或无权访问时不再重连
+      if (event.code >= 4400 && event.code < 4500) {
+        console.warn('协同编辑连接被拒绝:', event.code, event.reason)
+        return
+      }
+      
       // 尝试重连
       setTimeout(() => {
         if (!this.connected) {
@@ -99,6 +113,7 @@ export class YjsCollabClient {
    * 断开连接
    */
   disconnect() {
+    clearInterval(this.awarenessTimer)
     if (this.ws) {
       this.ws.close()
       this.ws = null
@@ -380,19 +395,61 @@ export class YjsCollabClient {
   setText(text) {
     const currentText = this.ytext.toString()
     if (currentText !== text) {
+      // 只替换首尾相同部分之间的文字，未改动的文字保持原样，评论锚点等相对位置不会失效
+      let start = 0
+      const maxStart = Math.min(currentText.length, text.length)
+      while (start < maxStart && currentText[start] === text[start]) {
+        start++
+      }
+      let end = 0
+      const maxEnd = maxStart - start
+      while (end < maxEnd && currentText[currentText.length - 1 - end] === text[text.length - 1 - end]) {
+        end++
+      }
+
       // 使用事务来批量更新
       // 不传递 origin，这样 handleDocUpdate 会将更新发送到服务器
       this.doc.transact(() => {
-        this.ytext.delete(0, currentText.length)
-        this.ytext.insert(0, text)
+        const deleteLength = currentText.length - start - end
+        if (deleteLength > 0) {
+          this.ytext.delete(start, deleteLength)
+        }
+        const inserted = text.slice(start, text.length - end)
+        if (inserted) {
+          this.ytext.insert(start, inserted)
+        }
       })
     }
   }
 
+  /**
+   * 为文本范围 [from, to) 创建评论锚点（base64 编码的 Yjs 相对位置）
+   */
+  createAnchor(from, to) {
+    const encode = (index, assoc) => {
+      const rpos = Y.createRelativePositionFromTypeIndex(this.ytext, index, assoc)
+      const bytes = Y.encodeRelativePosition(rpos)
+      return btoa(String.fromCharCode(...bytes))
+    }
+    // 与服务器一致：起点关联到范围内的第一个字符，终点关联到范围内的最后一个字符
+    return {
+      anchor_start: encode(from, 0),
+      anchor_end: encode(to, -1)
+    }
+  }
+
   /**
    * 发送光标位置
    */
   sendCursorPosition(from, to) {
+    this.lastCursor = { from, to }
+    this.renewAwareness()
+  }
+
+  /**
+   * 发送当前感知状态（最近的光标位置），也用于定期续期
+   */
+  renewAwareness() {
     if (!this.connected || !this.synced) {
       return
     }
@@ -400,12 +457,7 @@ export class YjsCollabClient {
     this.sendMessage({
       type: 'awareness',
       noteId: this.noteId,
-      data: {
-        cursor: {
-          from: from,
-          to: to
-        }
-      }
+      data: this.lastCursor ? { cursor: this.lastCursor } : {}
     })
   }
 